@@ -0,0 +1,70 @@
+package abandoned
+
+import (
+	"3legant/mailer"
+	"3legant/types"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultIdle is how long a cart has to sit untouched before it counts as
+// abandoned.
+const DefaultIdle = 24 * time.Hour
+
+type Store interface {
+	GetAbandonedCarts(time.Duration) ([]*types.AbandonedCart, error)
+	MarkCartReminded(int) error
+}
+
+// Reminder emails the owners of abandoned carts. Each cart is reminded once
+// per period of inactivity.
+type Reminder struct {
+	Store  Store
+	Mailer mailer.Mailer
+	Idle   time.Duration
+}
+
+func (r *Reminder) Run() error {
+	carts, err := r.Store.GetAbandonedCarts(r.Idle)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, cart := range carts {
+		if err := r.remind(cart); err != nil {
+			log.Printf("cart %d reminder: %v", cart.CartID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cart reminders failed", failed, len(carts))
+	}
+	return nil
+}
+
+func (r *Reminder) remind(cart *types.AbandonedCart) error {
+	if cart.Email == "" {
+		// nothing to send to; mark the cart so it is not retried every run
+		log.Printf("cart %d reminder skipped: account %d has no email", cart.CartID, cart.UserID)
+		return r.Store.MarkCartReminded(cart.CartID)
+	}
+	msg := &mailer.Message{
+		To:      cart.Email,
+		Subject: "You left something in your cart",
+		Body: fmt.Sprintf("Hi %s,\n\nyou still have %d %s waiting in your cart.\n\n"+
+			"Come back any time to complete your order.\n",
+			cart.FirstName, cart.Items, plural(cart.Items, "item", "items")),
+	}
+	if err := r.Mailer.Send(msg); err != nil {
+		return err
+	}
+	return r.Store.MarkCartReminded(cart.CartID)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,86 @@
+package address
+
+import (
+	"3legant/types"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxFieldLength = 100
+
+// rule lists what an address in a country needs beyond name, street, city
+// and country.
+type rule struct {
+	region bool
+	postal *regexp.Regexp
+}
+
+var rules = map[string]rule{
+	"US": {region: true, postal: regexp.MustCompile(`^\d{5}(-\d{4})?$`)},
+	"CA": {region: true, postal: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`)},
+	"AU": {region: true, postal: regexp.MustCompile(`^\d{4}$`)},
+	"GB": {postal: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`)},
+	"DE": {postal: regexp.MustCompile(`^\d{5}$`)},
+	"FR": {postal: regexp.MustCompile(`^\d{5}$`)},
+	"NL": {postal: regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`)},
+}
+
+// countriesWithoutPostalCodes may leave the postal code empty.
+var countriesWithoutPostalCodes = map[string]bool{
+	"AE": true, "HK": true, "IE": true, "QA": true,
+}
+
+// Normalize trims the fields and upper cases country and postal code.
+func Normalize(addr *types.Address) {
+	addr.Name = strings.TrimSpace(addr.Name)
+	addr.Line1 = strings.TrimSpace(addr.Line1)
+	addr.Line2 = strings.TrimSpace(addr.Line2)
+	addr.City = strings.TrimSpace(addr.City)
+	addr.Region = strings.TrimSpace(addr.Region)
+	addr.PostalCode = strings.ToUpper(strings.TrimSpace(addr.PostalCode))
+	addr.Country = strings.ToUpper(strings.TrimSpace(addr.Country))
+	addr.Phone = strings.TrimSpace(addr.Phone)
+}
+
+// Validate checks that the address has the fields its country requires.
+func Validate(addr *types.Address) error {
+	if addr.Kind != types.AddressShipping && addr.Kind != types.AddressBilling {
+		return fmt.Errorf("kind must be shipping or billing")
+	}
+	if len(addr.Country) != 2 {
+		return fmt.Errorf("country must be a two letter code")
+	}
+	required := []struct{ field, value string }{
+		{"name", addr.Name},
+		{"line1", addr.Line1},
+		{"city", addr.City},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return fmt.Errorf("%s is required", r.field)
+		}
+		if len(r.value) > maxFieldLength {
+			return fmt.Errorf("%s must be at most %d characters", r.field, maxFieldLength)
+		}
+	}
+	for _, value := range []string{addr.Line2, addr.Region, addr.Phone} {
+		if len(value) > maxFieldLength {
+			return fmt.Errorf("address fields must be at most %d characters", maxFieldLength)
+		}
+	}
+	r := rules[addr.Country]
+	if r.region && addr.Region == "" {
+		return fmt.Errorf("region is required in %s", addr.Country)
+	}
+	if addr.PostalCode == "" {
+		if countriesWithoutPostalCodes[addr.Country] {
+			return nil
+		}
+		return fmt.Errorf("postalCode is required in %s", addr.Country)
+	}
+	if r.postal != nil && !r.postal.MatchString(addr.PostalCode) {
+		return fmt.Errorf("invalid postal code %s for %s", addr.PostalCode, addr.Country)
+	}
+	return nil
+}
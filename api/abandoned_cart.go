@@ -0,0 +1,32 @@
+package api
+
+import (
+	"3legant/abandoned"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// handleCartMetrics reports how many carts are abandoned. The idle query
+// parameter overrides how long a cart must be untouched to count.
+func (s *Server) handleCartMetrics(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	idle := abandoned.DefaultIdle
+	if str := r.URL.Query().Get("idle"); str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("idle must be positive")
+		}
+		idle = d
+	}
+	stats, err := s.store.GetCartAbandonmentStats(idle)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, stats)
+}
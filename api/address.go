@@ -0,0 +1,83 @@
+package api
+
+import (
+	"3legant/address"
+	"3legant/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (s *Server) handleAddresses(w http.ResponseWriter, r *http.Request) error {
+	accID, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == "GET" {
+		addrs, err := s.store.GetAddresses(accID)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, addrs)
+	}
+	if r.Method == "POST" {
+		addr, err := decodeAddress(r, accID)
+		if err != nil {
+			return err
+		}
+		if err := s.store.CreateAddress(addr); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, addr)
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func (s *Server) handleAddressByID(w http.ResponseWriter, r *http.Request) error {
+	accID, err := getID(r)
+	if err != nil {
+		return err
+	}
+	addressID, err := getIntVar(r, "addressID")
+	if err != nil {
+		return err
+	}
+	if r.Method == "GET" {
+		addr, err := s.store.GetAddress(accID, addressID)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, addr)
+	}
+	if r.Method == "PUT" {
+		addr, err := decodeAddress(r, accID)
+		if err != nil {
+			return err
+		}
+		addr.ID = addressID
+		if err := s.store.UpdateAddress(addr); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, addr)
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.DeleteAddress(accID, addressID); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"deleted": addressID})
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func decodeAddress(r *http.Request, accID int) (*types.Address, error) {
+	req := new(types.AddressRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, err
+	}
+	addr := types.NewAddress(accID, req)
+	address.Normalize(addr)
+	if err := address.Validate(addr); err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
@@ -1,8 +1,18 @@
 package api
 
 import (
+	"3legant/blob"
+	"3legant/invoice"
+	"3legant/mailer"
+	"3legant/moderation"
+	"3legant/orders"
+	"3legant/payments"
+	"3legant/pricing"
+	"3legant/shipping"
 	"3legant/storage"
+	"3legant/types"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"log"
@@ -18,17 +28,81 @@ func (s *Server) Run() {
 	router.HandleFunc("/accounts", adminMiddleware(makeHTTPHandleFunc(s.handleAccount)))
 	router.HandleFunc("/accounts/{id}", adminMiddleware(makeHTTPHandleFunc(s.handleGetAccountByID)))
 
-	router.HandleFunc("/products", makeHTTPHandleFunc(s.handleProduct))
-	router.HandleFunc("/products/{id}", makeHTTPHandleFunc(s.handleGetProductByID))
-	//router.HandleFunc("/products/new", makeHTTPHandleFunc(s.handleGetNewProducts))
-
+	router.HandleFunc("/products", s.idempotent(makeHTTPHandleFunc(s.handleProduct)))
+	// review routes must be registered before /products/{id} so they are not
+	// swallowed by it
 	router.HandleFunc("/products/reviews", makeHTTPHandleFunc(s.handleReview))
 	router.HandleFunc("/products/reviews/{id}", makeHTTPHandleFunc(s.handleGetReviewByID))
+	router.HandleFunc("/products/reviews/{id}/vote", makeHTTPHandleFunc(s.handleReviewVote))
+	router.HandleFunc("/products/reviews/{id}/reply", adminMiddleware(makeHTTPHandleFunc(s.handleReviewReply)))
+	router.HandleFunc("/products/reviews/{id}/report", makeHTTPHandleFunc(s.handleReportReview))
+	router.HandleFunc("/products/reviews/{id}/images", makeHTTPHandleFunc(s.handleUploadReviewImages))
+	router.HandleFunc("/products/reviews/{id}/images/{imageID}", makeHTTPHandleFunc(s.handleDeleteReviewImage))
+	router.HandleFunc("/products/{id}", makeHTTPHandleFunc(s.handleGetProductByID))
+	router.HandleFunc("/products/{id}/related", makeHTTPHandleFunc(s.handleGetRelatedProducts))
+	router.HandleFunc("/products/{id}/reviews", makeHTTPHandleFunc(s.handleGetProductReviews))
+	//router.HandleFunc("/products/new", makeHTTPHandleFunc(s.handleGetNewProducts))
 
 	router.HandleFunc("/products/categories", makeHTTPHandleFunc(s.handleGetCategory))
 	//router.HandleFunc("/products/search", makeHTTPHandleFunc(s.handleSearchProduct))
 
 	router.HandleFunc("/carts/{id}", userMiddleware(makeHTTPHandleFunc(s.HandleCart)))
+	router.HandleFunc("/carts/{id}/items/{prodID}", userMiddleware(makeHTTPHandleFunc(s.HandleCartItem)))
+	router.HandleFunc("/carts/{id}/shipping", userMiddleware(makeHTTPHandleFunc(s.handleCartShipping)))
+	router.HandleFunc("/carts/{id}/coupon", userMiddleware(makeHTTPHandleFunc(s.handleCartCoupon)))
+	router.HandleFunc("/guest/cart", makeHTTPHandleFunc(s.handleGuestCart))
+	router.HandleFunc("/guest/cart/items/{prodID}", makeHTTPHandleFunc(s.handleGuestCartItem))
+	router.HandleFunc("/guest/cart/shipping", makeHTTPHandleFunc(s.handleGuestCartShipping))
+	router.HandleFunc("/guest/cart/coupon", makeHTTPHandleFunc(s.handleGuestCartCoupon))
+
+	router.HandleFunc("/checkout", s.idempotent(makeHTTPHandleFunc(s.handleCheckout)))
+	router.HandleFunc("/orders", makeHTTPHandleFunc(s.handleOrders))
+	router.HandleFunc("/orders/{id}", makeHTTPHandleFunc(s.handleOrderByID))
+	router.HandleFunc("/orders/{id}/cancel", makeHTTPHandleFunc(s.handleCancelOrder))
+	router.HandleFunc("/orders/{id}/pay", s.idempotent(makeHTTPHandleFunc(s.handlePayOrder)))
+	router.HandleFunc("/orders/{id}/invoice", makeHTTPHandleFunc(s.handleOrderInvoice))
+	router.HandleFunc("/payments/webhook", makeHTTPHandleFunc(s.handlePaymentWebhook))
+	router.HandleFunc("/payments/fake/challenge/{intentID}", makeHTTPHandleFunc(s.handleFakeChallenge))
+	router.HandleFunc("/orders/{id}/status", adminMiddleware(makeHTTPHandleFunc(s.handleOrderStatus)))
+	router.HandleFunc("/orders/{id}/returns", makeHTTPHandleFunc(s.handleOrderReturns))
+	router.HandleFunc("/returns", adminMiddleware(makeHTTPHandleFunc(s.handleReturns)))
+	router.HandleFunc("/returns/{id}", makeHTTPHandleFunc(s.handleReturnByID))
+	router.HandleFunc("/returns/{id}/approve", adminMiddleware(makeHTTPHandleFunc(s.handleApproveReturn)))
+	router.HandleFunc("/returns/{id}/reject", adminMiddleware(makeHTTPHandleFunc(s.handleRejectReturn)))
+	router.HandleFunc("/returns/{id}/receive", adminMiddleware(makeHTTPHandleFunc(s.handleReceiveReturn)))
+	router.HandleFunc("/returns/{id}/refund", adminMiddleware(makeHTTPHandleFunc(s.handleRefundReturn)))
+
+	router.HandleFunc("/metrics/carts", adminMiddleware(makeHTTPHandleFunc(s.handleCartMetrics)))
+
+	router.HandleFunc("/promotions", adminMiddleware(makeHTTPHandleFunc(s.handlePromotions)))
+	router.HandleFunc("/promotions/{id}", adminMiddleware(makeHTTPHandleFunc(s.handlePromotionByID)))
+
+	router.HandleFunc("/webhooks", adminMiddleware(makeHTTPHandleFunc(s.handleWebhooks)))
+	router.HandleFunc("/webhooks/{id}", adminMiddleware(makeHTTPHandleFunc(s.handleWebhookByID)))
+	router.HandleFunc("/webhooks/{id}/deliveries", adminMiddleware(makeHTTPHandleFunc(s.handleWebhookDeliveries)))
+	router.HandleFunc("/webhooks/deliveries/{id}/replay", adminMiddleware(makeHTTPHandleFunc(s.handleReplayDelivery)))
+
+	router.HandleFunc("/wishlists/{id}", userMiddleware(makeHTTPHandleFunc(s.handleWishlists)))
+	router.HandleFunc("/wishlists/{id}/{wishlistID}", userMiddleware(makeHTTPHandleFunc(s.handleWishlistByID)))
+	router.HandleFunc("/wishlists/{id}/{wishlistID}/items", userMiddleware(makeHTTPHandleFunc(s.handleAddProductToWishlist)))
+	router.HandleFunc("/wishlists/{id}/{wishlistID}/items/{prodID}", userMiddleware(makeHTTPHandleFunc(s.handleDeleteProductFromWishlist)))
+	router.HandleFunc("/wishlists/{id}/{wishlistID}/items/{prodID}/cart", userMiddleware(makeHTTPHandleFunc(s.handleMoveWishlistProductToCart)))
+	router.HandleFunc("/wishlists/{id}/{wishlistID}/share", userMiddleware(makeHTTPHandleFunc(s.handleShareWishlist)))
+	router.HandleFunc("/shared/wishlists/{token}", makeHTTPHandleFunc(s.handleGetSharedWishlist))
+
+	router.HandleFunc("/addresses/{id}", userMiddleware(makeHTTPHandleFunc(s.handleAddresses)))
+	router.HandleFunc("/addresses/{id}/{addressID}", userMiddleware(makeHTTPHandleFunc(s.handleAddressByID)))
+
+	router.HandleFunc("/moderation/reviews", adminMiddleware(makeHTTPHandleFunc(s.handleGetModerationQueue)))
+	router.HandleFunc("/moderation/reviews/{id}/approve", adminMiddleware(makeHTTPHandleFunc(s.handleApproveReview)))
+	router.HandleFunc("/moderation/reviews/{id}/reject", adminMiddleware(makeHTTPHandleFunc(s.handleRejectReview)))
+	router.HandleFunc("/moderation/reports", adminMiddleware(makeHTTPHandleFunc(s.handleGetReviewReports)))
+	router.HandleFunc("/moderation/reports/{id}/resolve", adminMiddleware(makeHTTPHandleFunc(s.handleResolveReviewReport)))
+	router.HandleFunc("/moderation/images", adminMiddleware(makeHTTPHandleFunc(s.handleGetReviewImageQueue)))
+	router.HandleFunc("/moderation/images/{id}/approve", adminMiddleware(makeHTTPHandleFunc(s.handleApproveReviewImage)))
+	router.HandleFunc("/moderation/images/{id}/reject", adminMiddleware(makeHTTPHandleFunc(s.handleRejectReviewImage)))
+
+	router.HandleFunc("/blobs/{key:.+}", makeHTTPHandleFunc(s.handleGetBlob))
 
 	log.Println("JSON API server running on port: ", s.listenAddr)
 
@@ -48,34 +122,79 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 type Server struct {
 	listenAddr string
 	store      storage.Storage
+	mailer     mailer.Mailer
+	blobs      blob.Store
+	pricing    *pricing.Engine
+	screener   moderation.Pipeline
+	orderFlow  *orders.Machine
+	payments   payments.Provider
+	shipping   *shipping.Calculator
+	issuer     *invoice.Issuer
 }
 
 type ServerError struct {
 	Error string `json:"error"`
 }
 
+// apiError is returned by handlers that need a status other than 400.
+type apiError struct {
+	Status int
+	Msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.Msg
+}
+
+func newAPIError(status int, format string, args ...any) error {
+	return &apiError{Status: status, Msg: fmt.Sprintf(format, args...)}
+}
+
 type apiFunc func(w http.ResponseWriter, r *http.Request) error
 
 func makeHTTPHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			WriteJSON(w, http.StatusBadRequest, ServerError{Error: err.Error()})
+			status := http.StatusBadRequest
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				status = apiErr.Status
+			}
+			WriteJSON(w, status, ServerError{Error: err.Error()})
 		}
 	}
 }
 
-func NewAPIServer(listenAddr string, store storage.Storage) *Server {
-	return &Server{
+func NewAPIServer(listenAddr string, store storage.Storage, mailer mailer.Mailer, blobs blob.Store,
+	pricing *pricing.Engine, payments payments.Provider, shipping *shipping.Calculator, issuer *invoice.Issuer) *Server {
+	s := &Server{
 		listenAddr: listenAddr,
 		store:      store,
+		mailer:     mailer,
+		blobs:      blobs,
+		pricing:    pricing,
+		screener:   moderation.DefaultPipeline(store),
+		orderFlow:  orders.NewMachine(store),
+		payments:   payments,
+		shipping:   shipping,
+		issuer:     issuer,
 	}
+	s.orderFlow.OnEnter(types.OrderPaid, s.sendOrderConfirmation)
+	// a failed release is retried by RetryPaymentReleases
+	s.orderFlow.OnEnter(types.OrderCancelled, s.releasePayments)
+	s.orderFlow.OnEnter(types.OrderRefunded, s.releasePayments)
+	return s
 }
 
 func getID(r *http.Request) (int, error) {
-	idStr := mux.Vars(r)["id"]
+	return getIntVar(r, "id")
+}
+
+func getIntVar(r *http.Request, name string) (int, error) {
+	idStr := mux.Vars(r)[name]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		return id, fmt.Errorf("invalid id given %s", idStr)
+		return id, fmt.Errorf("invalid %s given %s", name, idStr)
 	}
 	return id, nil
 }
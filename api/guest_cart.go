@@ -0,0 +1,129 @@
+package api
+
+import (
+	"3legant/types"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	guestCartCookie = "guest_cart"
+	guestCartMaxAge = 30 * 24 * time.Hour
+)
+
+// handleGuestCart serves the cart of a visitor who is not logged in. The cart
+// is identified by a signed cookie that is created with the first item.
+func (s *Server) handleGuestCart(w http.ResponseWriter, r *http.Request) error {
+	cart, err := s.getGuestCart(r)
+	if err != nil {
+		return err
+	}
+	if cart == nil {
+		if r.Method == "GET" || r.Method == "DELETE" {
+			return s.handleGetCart(w, r, &types.Cart{})
+		}
+		if cart, err = s.createGuestCart(w); err != nil {
+			return err
+		}
+	}
+	return s.serveCart(w, r, cart)
+}
+
+func (s *Server) handleGuestCartItem(w http.ResponseWriter, r *http.Request) error {
+	cart, err := s.getGuestCart(r)
+	if err != nil {
+		return err
+	}
+	if cart == nil {
+		return fmt.Errorf("cart is empty")
+	}
+	return s.serveCartItem(w, r, cart)
+}
+
+// getGuestCart returns the cart referenced by the guest cookie, or nil if
+// there is no valid one.
+func (s *Server) getGuestCart(r *http.Request) (*types.Cart, error) {
+	cookie, err := r.Cookie(guestCartCookie)
+	if err != nil {
+		return nil, nil
+	}
+	token, ok := verifyGuestToken(cookie.Value)
+	if !ok {
+		return nil, nil
+	}
+	cart, err := s.store.GetCartByGuestToken(token)
+	if err != nil {
+		return nil, nil
+	}
+	return cart, nil
+}
+
+func (s *Server) createGuestCart(w http.ResponseWriter) (*types.Cart, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	cart, err := s.store.CreateGuestCart(token)
+	if err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCartCookie,
+		Value:    signGuestToken(token),
+		Path:     "/",
+		Expires:  time.Now().Add(guestCartMaxAge),
+		HttpOnly: true,
+	})
+	return cart, nil
+}
+
+// mergeGuestCart moves the items of the visitor's guest cart into the
+// account's cart and drops the guest cookie.
+func (s *Server) mergeGuestCart(w http.ResponseWriter, r *http.Request, accID int) error {
+	guest, err := s.getGuestCart(r)
+	if err != nil || guest == nil {
+		return err
+	}
+	cart, err := s.store.GetCartByUserID(accID)
+	if err != nil {
+		return err
+	}
+	if err := s.store.MergeCarts(guest.CartID, cart.CartID); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCartCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return nil
+}
+
+func guestTokenMAC(token string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("guest-cart:" + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signGuestToken(token string) string {
+	return fmt.Sprintf("%s.%s", token, guestTokenMAC(token))
+}
+
+func verifyGuestToken(value string) (string, bool) {
+	token, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	if !hmac.Equal([]byte(sig), []byte(guestTokenMAC(token))) {
+		return "", false
+	}
+	return token, true
+}
@@ -1,15 +1,18 @@
 package api
 
 import (
+	"3legant/tax"
 	"3legant/types"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"log"
 	"math"
 	"net/http"
 	"regexp"
 	"strconv"
 	"time"
+	"unicode/utf8"
 )
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) error {
@@ -47,6 +50,14 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) error {
 
 	http.SetCookie(w, &cookie)
 
+	if acc.UserType == types.UserTypeRegular {
+		// the login already succeeded, a guest cart that could not be
+		// merged is kept and merged on the next login
+		if err := s.mergeGuestCart(w, r, acc.ID); err != nil {
+			log.Printf("merge guest cart into account %d: %v", acc.ID, err)
+		}
+	}
+
 	return WriteJSON(w, http.StatusOK, "Login successful")
 }
 
@@ -206,9 +217,6 @@ func (s *Server) handleGetProductByID(w http.ResponseWriter, r *http.Request) er
 	if idStr == "categories" {
 		return s.handleGetCategory(w, r)
 	}
-	if idStr == "reviews" {
-		return s.handleGetReview(w, r)
-	}
 	//if strings.HasPrefix(idStr, "search") {
 	//	return s.handleSearchProduct(w, r)
 	//}
@@ -243,6 +251,9 @@ func (s *Server) handleUpdateProduct(w http.ResponseWriter, r *http.Request) err
 	if err != nil {
 		return err
 	}
+	if product.TaxClass, err = tax.ParseClass(string(product.TaxClass)); err != nil {
+		return err
+	}
 	if err := s.store.UpdateProduct(id, &product); err != nil {
 		return err
 	}
@@ -257,13 +268,19 @@ func (s *Server) handleGetProduct(w http.ResponseWriter, r *http.Request) error
 	priceTo := vars.Get("priceTo")
 	skip := vars.Get("skip")
 	limit := vars.Get("limit")
+	minRating := vars.Get("minRating")
+	sort := vars.Get("sort")
 	if priceFrom == "" {
 		priceFrom = "0"
 	}
 	if priceTo == "" {
 		priceTo = fmt.Sprintf("%f", math.MaxFloat32)
 	}
-	params := map[string]any{"name": name, "priceFrom": priceFrom, "priceTo": priceTo, "skip": skip, "limit": limit}
+	if minRating == "" {
+		minRating = "0"
+	}
+	params := map[string]any{"name": name, "priceFrom": priceFrom, "priceTo": priceTo, "skip": skip, "limit": limit,
+		"minRating": minRating, "sort": sort}
 	products, err := s.store.SearchProducts(params)
 	if err != nil {
 		return err
@@ -281,6 +298,13 @@ func (s *Server) handleCreateProduct(w http.ResponseWriter, r *http.Request) err
 		createProductReq.Measurements,
 		createProductReq.Description,
 		createProductReq.Packaging)
+	product.Stock = createProductReq.Stock
+	product.Weight = createProductReq.Weight
+	taxClass, err := tax.ParseClass(string(createProductReq.TaxClass))
+	if err != nil {
+		return err
+	}
+	product.TaxClass = taxClass
 	if err := s.store.CreateProduct(product); err != nil {
 		return err
 	}
@@ -292,15 +316,8 @@ func (s *Server) handleDeleteProduct(w http.ResponseWriter, r *http.Request) err
 	if err != nil {
 		return err
 	}
-	err1, err2, err3 := s.store.DeleteProduct(id)
-	if err1 != nil {
-		return err1
-	}
-	if err2 != nil {
-		return err2
-	}
-	if err3 != nil {
-		return err3
+	if err := s.store.DeleteProduct(id); err != nil {
+		return err
 	}
 	return WriteJSON(w, http.StatusOK, map[string]int{"deleted": id})
 }
@@ -332,6 +349,41 @@ func (s *Server) handleSearchProduct(w http.ResponseWriter, r *http.Request) err
 	return WriteJSON(w, http.StatusOK, products)
 }
 
+const (
+	defaultRelatedLimit = 8
+	maxRelatedLimit     = 50
+)
+
+func (s *Server) handleGetRelatedProducts(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	vars := r.URL.Query()
+	limit := defaultRelatedLimit
+	if limitStr := vars.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid limit %s", limitStr)
+		}
+	}
+	if limit > maxRelatedLimit {
+		limit = maxRelatedLimit
+	}
+	together := vars.Get("type") == "together"
+	if _, err := s.store.GetProductByID(id); err != nil {
+		return err
+	}
+	related, err := s.store.GetRelatedProducts(id, limit, together)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, related)
+}
+
 // REVIEW
 
 func (s *Server) handleReview(w http.ResponseWriter, r *http.Request) error {
@@ -341,14 +393,11 @@ func (s *Server) handleReview(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "POST" {
 		return s.handleCreateReview(w, r)
 	}
-	if r.Method == "PUT" {
-		return s.handleUpdateReview(w, r)
-	}
 	//if r.Method == "DELETE" {
 	//	return s.handleDeleteReview(w, r)
 	//}
 
-	return nil
+	return fmt.Errorf("method not allowed %s", r.Method)
 }
 func (s *Server) handleGetReviewByID(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
@@ -360,8 +409,20 @@ func (s *Server) handleGetReviewByID(w http.ResponseWriter, r *http.Request) err
 		if err != nil {
 			return err
 		}
+		caller, err := getTokenAccount(r)
+		privileged := err == nil && (caller.ID == account.AccID || caller.canModerate())
+		if account.Status != types.ReviewStatusApproved && !privileged {
+			// unpublished reviews are only visible to their author and moderators
+			return newAPIError(http.StatusNotFound, "review %d not found", id)
+		}
+		if !privileged {
+			account.Images = approvedImages(account.Images)
+		}
 		return WriteJSON(w, http.StatusOK, account)
 	}
+	if r.Method == "PUT" {
+		return s.handleUpdateReview(w, r)
+	}
 	if r.Method == "DELETE" {
 		return s.handleDeleteReview(w, r)
 	}
@@ -376,33 +437,135 @@ func (s *Server) handleGetReview(w http.ResponseWriter, r *http.Request) error {
 	return WriteJSON(w, http.StatusOK, reviews)
 }
 
+const (
+	defaultReviewPageSize = 10
+	maxReviewPageSize     = 100
+)
+
+func (s *Server) handleGetProductReviews(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	query, err := parseReviewQuery(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.store.GetProductByID(id); err != nil {
+		return err
+	}
+	page, err := s.store.GetProductReviews(id, query)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, page)
+}
+
+func parseReviewQuery(r *http.Request) (*types.ReviewQuery, error) {
+	vars := r.URL.Query()
+	query := &types.ReviewQuery{
+		Page:     1,
+		PageSize: defaultReviewPageSize,
+		Sort:     vars.Get("sort"),
+	}
+	var err error
+	if page := vars.Get("page"); page != "" {
+		query.Page, err = strconv.Atoi(page)
+		if err != nil || query.Page < 1 {
+			return nil, fmt.Errorf("invalid page %s", page)
+		}
+	}
+	if pageSize := vars.Get("pageSize"); pageSize != "" {
+		query.PageSize, err = strconv.Atoi(pageSize)
+		if err != nil || query.PageSize < 1 {
+			return nil, fmt.Errorf("invalid pageSize %s", pageSize)
+		}
+	}
+	if query.PageSize > maxReviewPageSize {
+		query.PageSize = maxReviewPageSize
+	}
+	switch query.Sort {
+	case "", "newest", "highest", "lowest", "helpful":
+	default:
+		return nil, fmt.Errorf("invalid sort %s", query.Sort)
+	}
+	if rating := vars.Get("rating"); rating != "" {
+		query.Rating, err = strconv.Atoi(rating)
+		if err != nil || query.Rating < 1 || query.Rating > 5 {
+			return nil, fmt.Errorf("invalid rating %s", rating)
+		}
+	}
+	if verified := vars.Get("verified"); verified != "" {
+		v, err := strconv.ParseBool(verified)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verified %s", verified)
+		}
+		query.Verified = &v
+	}
+	return query, nil
+}
+
 func (s *Server) handleUpdateReview(w http.ResponseWriter, r *http.Request) error {
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
 	id, err1 := getID(r)
 	if err1 != nil {
 		return err1
 	}
-	var review types.Review
-	err := json.NewDecoder(r.Body).Decode(&review)
+	existing, err := s.getOwnReview(caller, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
 		return err
 	}
-	if err := s.store.UpdateReview(id, &review); err != nil {
+	var req types.UpdateReviewRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
 		return err
 	}
-	if r.Method == "PUT" {
-		return s.handleUpdateAccount(w, r)
+	if err := validateReview(req.RatingGiven, req.Text); err != nil {
+		return err
 	}
-	return WriteJSON(w, http.StatusOK, map[string]int{"updated": id})
+	review := types.NewReview(existing.AccID, existing.ProdID, req.RatingGiven, req.Text)
+	if err := s.screenReview(review); err != nil {
+		return err
+	}
+	if err := s.store.UpdateReview(id, review); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]any{"updated": id, "status": review.Status})
 }
 
 func (s *Server) handleCreateReview(w http.ResponseWriter, r *http.Request) error {
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
 	createReviewReq := new(types.CreateReviewRequest)
 	if err := json.NewDecoder(r.Body).Decode(createReviewReq); err != nil {
 		return err
 	}
+	if err := validateReview(createReviewReq.RatingGiven, createReviewReq.Text); err != nil {
+		return err
+	}
+	if _, err := s.store.GetProductByID(createReviewReq.ProdID); err != nil {
+		return err
+	}
+	reviewed, err := s.store.HasReviewed(caller.ID, createReviewReq.ProdID)
+	if err != nil {
+		return err
+	}
+	if reviewed {
+		return newAPIError(http.StatusConflict, "product %d already reviewed", createReviewReq.ProdID)
+	}
 
-	review := types.NewReview(createReviewReq.AccID, createReviewReq.ProdID, createReviewReq.RatingGiven, createReviewReq.Text)
+	review := types.NewReview(caller.ID, createReviewReq.ProdID, createReviewReq.RatingGiven, createReviewReq.Text)
+	if err := s.screenReview(review); err != nil {
+		return err
+	}
 	if err := s.store.CreateReview(review); err != nil {
 		return err
 	}
@@ -410,16 +573,49 @@ func (s *Server) handleCreateReview(w http.ResponseWriter, r *http.Request) erro
 }
 
 func (s *Server) handleDeleteReview(w http.ResponseWriter, r *http.Request) error {
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
 	id, err := getID(r)
 	if err != nil {
 		return err
 	}
+	review, err := s.getOwnReview(caller, id)
+	if err != nil {
+		return err
+	}
 	if err := s.store.DeleteReview(id); err != nil {
 		return err
 	}
+	s.deleteBlobs(review.Images...)
 	return WriteJSON(w, http.StatusOK, map[string]int{"deleted": id})
 }
 
+// getOwnReview returns the review if the caller wrote it or may moderate it.
+func (s *Server) getOwnReview(caller *tokenAccount, id int) (*types.Review, error) {
+	review, err := s.store.GetReviewByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if review.AccID != caller.ID && !caller.canModerate() {
+		return nil, newAPIError(http.StatusForbidden, "permission denied")
+	}
+	return review, nil
+}
+
+const maxReviewTextLength = 200
+
+func validateReview(rating float64, text string) error {
+	if rating < 1 || rating > 5 {
+		return fmt.Errorf("rating must be between 1 and 5")
+	}
+	if utf8.RuneCountInString(text) > maxReviewTextLength {
+		return fmt.Errorf("review text must be at most %d characters", maxReviewTextLength)
+	}
+	return nil
+}
+
 // CATEGORY
 
 func (s *Server) handleGetCategory(w http.ResponseWriter, r *http.Request) error {
@@ -433,84 +629,130 @@ func (s *Server) handleGetCategory(w http.ResponseWriter, r *http.Request) error
 // CART
 
 func (s *Server) HandleCart(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	cart, err := s.store.GetCartByUserID(id)
+	if err != nil {
+		return err
+	}
+	return s.serveCart(w, r, cart)
+}
+
+// serveCart implements the cart operations shared by account and guest carts.
+func (s *Server) serveCart(w http.ResponseWriter, r *http.Request, cart *types.Cart) error {
 
 	if r.Method == "GET" {
-		return s.handleGetCart(w, r)
+		return s.handleGetCart(w, r, cart)
 	}
 	if r.Method == "POST" {
-		return s.handleAddProductToCart(w, r)
+		return s.handleAddProductToCart(w, r, cart)
 	}
 	if r.Method == "PUT" {
-		return s.handleUpdateProductQuantityInCart(w, r)
+		return s.handleUpdateProductQuantityInCart(w, r, cart)
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.ClearCart(cart.CartID); err != nil {
+			return err
+		}
+		return s.handleGetCart(w, r, cart)
 	}
 	return fmt.Errorf("method not allowed %s", r.Method)
 
 }
 
-func (s *Server) handleGetCart(w http.ResponseWriter, r *http.Request) error {
+func (s *Server) HandleCartItem(w http.ResponseWriter, r *http.Request) error {
 	id, err := getID(r)
 	if err != nil {
 		return err
 	}
+	cart, err := s.store.GetCartByUserID(id)
+	if err != nil {
+		return err
+	}
+	return s.serveCartItem(w, r, cart)
+}
 
-	prodQuantities := []*types.ProductQuantity{} // Создаем слайс для хранения пар продукт-количество
+// serveCartItem removes a single product from the cart.
+func (s *Server) serveCartItem(w http.ResponseWriter, r *http.Request, cart *types.Cart) error {
+	if r.Method != "DELETE" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	prodID, err := getIntVar(r, "prodID")
+	if err != nil {
+		return err
+	}
+	if err := s.store.DeleteProductFromCart(cart.CartID, prodID); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int{"deleted": prodID})
+}
 
-	carts, err := s.store.GetCartProductsByUserID(id)
+func (s *Server) handleGetCart(w http.ResponseWriter, r *http.Request, cart *types.Cart) error {
+	summary, err := s.getCartSummary(cart)
 	if err != nil {
 		return err
 	}
+	return WriteJSON(w, http.StatusOK, summary)
+}
+
+// getCartSummary prices the current contents of the cart.
+func (s *Server) getCartSummary(cart *types.Cart) (*types.CartSummary, error) {
+	prodQuantities := []*types.ProductQuantity{} // Создаем слайс для хранения пар продукт-количество
+
+	carts, err := s.store.GetCartProducts(cart.CartID)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, cart := range carts {
 		prod, err := s.store.GetProductByID(cart.ProdID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		prodQuantity := &types.ProductQuantity{
-			Product:  prod,
-			Quantity: cart.Quantity,
+			Product:    prod,
+			Quantity:   cart.Quantity,
+			AddedPrice: cart.UnitPrice,
 		}
 		prodQuantities = append(prodQuantities, prodQuantity)
 	}
 
-	return WriteJSON(w, http.StatusOK, prodQuantities) // Возвращаем слайс вместо карты
+	return s.pricing.Price(cart, prodQuantities)
 }
 
-func (s *Server) handleAddProductToCart(w http.ResponseWriter, r *http.Request) error {
-	cartID, err := getID(r)
-	vars := r.URL.Query()
-	prodID, err := strconv.Atoi(vars.Get("prodID"))
-	if err != nil {
-		return err
-	}
-	quantity, err := strconv.Atoi(vars.Get("quantity"))
+func (s *Server) handleAddProductToCart(w http.ResponseWriter, r *http.Request, cart *types.Cart) error {
+	req, err := decodeCartItem(r)
 	if err != nil {
 		return err
 	}
-	err = s.store.AddProductToCart(cartID, prodID, quantity)
-	if err != nil {
+	if err := s.store.AddProductToCart(cart.CartID, req.ProdID, req.Quantity); err != nil {
 		return err
 	}
-	return WriteJSON(w, http.StatusOK, map[string]int{"added: ": prodID})
+	return WriteJSON(w, http.StatusOK, map[string]int{"added": req.ProdID})
 }
 
-func (s *Server) handleUpdateProductQuantityInCart(w http.ResponseWriter, r *http.Request) error {
-	cartID, err := getID(r)
+func (s *Server) handleUpdateProductQuantityInCart(w http.ResponseWriter, r *http.Request, cart *types.Cart) error {
+	req, err := decodeCartItem(r)
 	if err != nil {
 		return err
 	}
-	vars := r.URL.Query()
-	prodID, err := strconv.Atoi(vars.Get("prodID"))
-	if err != nil {
+	if err := s.store.UpdateProductQuantityInCart(cart.CartID, req.ProdID, req.Quantity); err != nil {
 		return err
 	}
-	quantity, err := strconv.Atoi(vars.Get("quantity"))
-	if err != nil {
-		return err
+	return WriteJSON(w, http.StatusOK, map[string]int{"updated": req.ProdID})
+}
+
+func decodeCartItem(r *http.Request) (*types.CartItemRequest, error) {
+	req := new(types.CartItemRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, err
 	}
-	if err := s.store.UpdateProductQuantityInCart(cartID, prodID, quantity); err != nil {
-		return err
+	if req.Quantity < 1 {
+		return nil, fmt.Errorf("quantity must be positive")
 	}
-	return WriteJSON(w, http.StatusOK, map[string]int{"updated": prodID})
+	return req, nil
 }
 
 func isValidEmail(email string) bool {
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"3legant/types"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// idempotencyTTL is how long a response is replayed to retries.
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyStale is how long a request may be in flight before its
+	// key is assumed abandoned, e.g. because the server crashed.
+	idempotencyStale        = 5 * time.Minute
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotent makes retries of requests sent with an Idempotency-Key header
+// safe. The first request with a key runs and its response is stored;
+// retries get the stored response replayed instead of running again. A key
+// reused for a different request is rejected, and so are retries that
+// arrive while the first request is still running.
+func (s *Server) idempotent(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == "GET" {
+			handlerFunc(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			WriteJSON(w, http.StatusBadRequest, ServerError{Error: "Idempotency-Key is too long"})
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest, ServerError{Error: err.Error()})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		rec := &types.IdempotencyRecord{
+			Scope:       idempotencyScope(r),
+			Key:         key,
+			Fingerprint: fingerprint(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyTTL),
+		}
+		held, err := s.store.ClaimIdempotencyKey(rec, idempotencyStale)
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, ServerError{Error: err.Error()})
+			return
+		}
+		if held != nil {
+			replay(w, held, rec.Fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		handlerFunc(recorder, r)
+		if recorder.status == http.StatusServiceUnavailable {
+			// handlers answer 503 only when they failed before changing
+			// anything, so the client may try again. Other errors are
+			// stored, their request may have placed an order or charged
+			// a card before failing.
+			if err := s.store.ReleaseIdempotencyKey(rec.Scope, rec.Key); err != nil {
+				log.Printf("release idempotency key %s: %v", key, err)
+			}
+			return
+		}
+		rec.Status = recorder.status
+		rec.ContentType = recorder.Header().Get("Content-Type")
+		rec.Body = recorder.body.Bytes()
+		if err := s.store.CompleteIdempotencyKey(rec); err != nil {
+			log.Printf("store response for idempotency key %s: %v", key, err)
+		}
+	}
+}
+
+// replay answers a retry from the record of the request that holds its key.
+func replay(w http.ResponseWriter, held *types.IdempotencyRecord, fp string) {
+	if held.Fingerprint != fp {
+		WriteJSON(w, http.StatusUnprocessableEntity,
+			ServerError{Error: "Idempotency-Key was already used for a different request"})
+		return
+	}
+	if held.Status == 0 {
+		w.Header().Set("Retry-After", "1")
+		WriteJSON(w, http.StatusConflict,
+			ServerError{Error: "a request with this Idempotency-Key is still being processed"})
+		return
+	}
+	if held.ContentType != "" {
+		w.Header().Set("Content-Type", held.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(held.Status)
+	w.Write(held.Body)
+}
+
+// idempotencyScope keeps keys of different callers and endpoints apart, so
+// one client cannot replay another's response by guessing its key.
+func idempotencyScope(r *http.Request) string {
+	accID := 0
+	if caller, err := getTokenAccount(r); err == nil {
+		accID = caller.ID
+	}
+	return fmt.Sprintf("%d %s %s", accID, r.Method, r.URL.Path)
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"3legant/blob"
+	"3legant/invoice"
+	"3legant/mailer"
+	"3legant/types"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// invoiceFormats maps the formats invoices are rendered in to their content
+// types.
+var invoiceFormats = map[string]string{
+	"pdf":  "application/pdf",
+	"html": "text/html; charset=utf-8",
+}
+
+func invoiceKey(inv *types.Invoice, format string) string {
+	return "invoices/" + inv.Number() + "." + format
+}
+
+// sendOrderConfirmation runs when an order is paid. It issues the order's
+// invoice and mails it to the customer along with the confirmation.
+func (s *Server) sendOrderConfirmation(order *types.Order) error {
+	inv, err := s.store.CreateInvoice(order.ID)
+	if err != nil {
+		return err
+	}
+	customer, err := s.store.GetAccountByID(order.AccID)
+	if err != nil {
+		return err
+	}
+	doc := &invoice.Document{Issuer: s.issuer, Invoice: inv, Order: order, Customer: customer}
+	msg := &mailer.Message{
+		To:      customer.Email,
+		Subject: fmt.Sprintf("Your order #%d is confirmed", order.ID),
+		Body: fmt.Sprintf("Hi %s,\n\nthank you for your order. We received your payment of %s "+
+			"and will let you know when the order ships.\n\nYour invoice %s is attached.\n",
+			customer.FirstName, order.Total, inv.Number()),
+	}
+	for _, format := range []string{"pdf", "html"} {
+		data, err := s.storeInvoice(doc, format)
+		if err != nil {
+			return err
+		}
+		msg.Attachments = append(msg.Attachments, &mailer.Attachment{
+			Filename:    doc.Filename(format),
+			ContentType: invoiceFormats[format],
+			Data:        data,
+		})
+	}
+	return s.mailer.Send(msg)
+}
+
+// storeInvoice renders the invoice in format and keeps it in blob storage,
+// so it reads the same however often it is downloaded.
+func (s *Server) storeInvoice(doc *invoice.Document, format string) ([]byte, error) {
+	var data []byte
+	var err error
+	if format == "pdf" {
+		data, err = invoice.PDF(doc)
+	} else {
+		data, err = invoice.HTML(doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.blobs.Put(invoiceKey(doc.Invoice, format), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// wasPaid reports whether the order ever entered the paid status, even if it
+// moved on or was cancelled since.
+func wasPaid(order *types.Order) bool {
+	for _, event := range order.History {
+		if event.To == types.OrderPaid {
+			return true
+		}
+	}
+	return false
+}
+
+// handleOrderInvoice downloads the invoice of a paid order as PDF, or as
+// HTML with ?format=html.
+func (s *Server) handleOrderInvoice(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	order, err := s.getOwnOrder(caller, id)
+	if err != nil {
+		return err
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "pdf"
+	}
+	ctype, ok := invoiceFormats[format]
+	if !ok {
+		return fmt.Errorf("invalid format %s", format)
+	}
+	inv, err := s.store.GetOrderInvoice(order.ID)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		if !wasPaid(order) {
+			return newAPIError(http.StatusNotFound, "order %d has not been invoiced", order.ID)
+		}
+		// issuing failed when the order was paid, CreateInvoice is
+		// idempotent so racing requests get the same invoice
+		if inv, err = s.store.CreateInvoice(order.ID); err != nil {
+			return err
+		}
+	}
+
+	var body io.Reader
+	rc, err := s.blobs.Get(invoiceKey(inv, format))
+	if errors.Is(err, blob.ErrNotFound) {
+		// storing failed when the invoice was issued, render it again
+		customer, err := s.store.GetAccountByID(order.AccID)
+		if err != nil {
+			return err
+		}
+		doc := &invoice.Document{Issuer: s.issuer, Invoice: inv, Order: order, Customer: customer}
+		data, err := s.storeInvoice(doc, format)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	} else if err != nil {
+		return err
+	} else {
+		defer rc.Close()
+		body = rc
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", inv.Number()+"."+format))
+	_, err = io.Copy(w, body)
+	return err
+}
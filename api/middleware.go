@@ -101,6 +101,40 @@ func userMiddleware(handlerFunc http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// tokenAccount is the caller as identified by the jwt cookie.
+type tokenAccount struct {
+	ID       int
+	UserType types.UserType
+}
+
+func (a *tokenAccount) canModerate() bool {
+	return a.UserType == types.UserTypeAdmin
+}
+
+// getTokenAccount returns the caller of r, or a 401 error if the request has
+// no valid jwt cookie.
+func getTokenAccount(r *http.Request) (*tokenAccount, error) {
+	notAuthenticated := newAPIError(http.StatusUnauthorized, "not authenticated")
+	cookie, err := r.Cookie("jwt")
+	if err != nil {
+		return nil, notAuthenticated
+	}
+	token, err := validateJWT(cookie.Value)
+	if err != nil || !token.Valid {
+		return nil, notAuthenticated
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, notAuthenticated
+	}
+	id, ok := claims["accountID"].(float64)
+	if !ok {
+		return nil, notAuthenticated
+	}
+	userType, _ := claims["userType"].(string)
+	return &tokenAccount{ID: int(id), UserType: types.UserType(userType)}, nil
+}
+
 func validateJWT(tokenString string) (*jwt.Token, error) {
 	secret := os.Getenv("JWT_SECRET")
 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"3legant/mailer"
+	"3legant/types"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const maxModerationReasonLength = 200
+
+// screenReview runs the review through the screening pipeline and sets its
+// status accordingly.
+func (s *Server) screenReview(review *types.Review) error {
+	status, reasons, err := s.screener.Screen(review)
+	if err != nil {
+		return err
+	}
+	review.Status = status
+	review.ModerationReason = truncate(strings.Join(reasons, "; "), maxModerationReasonLength)
+	return nil
+}
+
+func (s *Server) handleGetModerationQueue(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	status := types.ReviewStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = types.ReviewStatusPending
+	}
+	switch status {
+	case types.ReviewStatusPending, types.ReviewStatusApproved, types.ReviewStatusRejected, types.ReviewStatusDuplicate:
+	default:
+		return fmt.Errorf("invalid status %s", status)
+	}
+	reviews, err := s.store.GetReviewsByStatus(status)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, reviews)
+}
+
+func (s *Server) handleApproveReview(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if err := s.store.SetReviewStatus(id, types.ReviewStatusApproved, ""); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int{"approved": id})
+}
+
+func (s *Server) handleRejectReview(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	req := new(types.RejectReviewRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return err
+	}
+	if len(req.Reason) == 0 {
+		return fmt.Errorf("empty reason")
+	}
+	reason := truncate(req.Reason, maxModerationReasonLength)
+	review, err := s.store.GetReviewByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.store.SetReviewStatus(id, types.ReviewStatusRejected, reason); err != nil {
+		return err
+	}
+	s.notifyReviewRejected(review, reason)
+	return WriteJSON(w, http.StatusOK, map[string]int{"rejected": id})
+}
+
+// notifyReviewRejected tells the author why their review was rejected.
+// Failures are logged; the rejection itself already happened.
+func (s *Server) notifyReviewRejected(review *types.Review, reason string) {
+	account, err := s.store.GetAccountByID(review.AccID)
+	if err != nil {
+		log.Printf("review %d rejection notice: %v", review.ID, err)
+		return
+	}
+	product, err := s.store.GetProductByID(review.ProdID)
+	if err != nil {
+		log.Printf("review %d rejection notice: %v", review.ID, err)
+		return
+	}
+	msg := &mailer.Message{
+		To:      account.Email,
+		Subject: "Your review was not published",
+		Body: fmt.Sprintf("Hi %s,\n\nyour review of %s was not published.\n\nReason: %s\n\n"+
+			"You can edit the review and it will be checked again.\n",
+			account.FirstName, product.Name, reason),
+	}
+	if err := s.mailer.Send(msg); err != nil {
+		log.Printf("review %d rejection notice: %v", review.ID, err)
+	}
+}
+
+func truncate(str string, n int) string {
+	runes := []rune(str)
+	if len(runes) <= n {
+		return str
+	}
+	return string(runes[:n])
+}
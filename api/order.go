@@ -0,0 +1,231 @@
+package api
+
+import (
+	"3legant/orders"
+	"3legant/storage"
+	"3legant/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// handleCheckout turns the caller's cart into an order.
+func (s *Server) handleCheckout(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	if caller.UserType != types.UserTypeRegular {
+		return newAPIError(http.StatusForbidden, "permission denied")
+	}
+	req := new(types.CheckoutRequest)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+	}
+
+	cart, err := s.store.GetCartByUserID(caller.ID)
+	if err != nil {
+		return err
+	}
+	shippingAddr, err := s.checkoutAddress(caller.ID, req.ShippingAddressID, types.AddressShipping)
+	if err != nil {
+		return err
+	}
+	if shippingAddr == nil {
+		return fmt.Errorf("add a shipping address first")
+	}
+	billingAddr, err := s.checkoutAddress(caller.ID, req.BillingAddressID, types.AddressBilling)
+	if err != nil {
+		return err
+	}
+	if billingAddr == nil {
+		billingAddr = shippingAddr
+	}
+	if cart.ShippingMethod == "" {
+		return fmt.Errorf("choose a shipping method first")
+	}
+	// the address decides where the order goes, whatever the cart was
+	// quoted for
+	cart.Destination = shippingAddr.Destination()
+	if err := s.shipping.Check(cart.Destination, cart.ShippingMethod); err != nil {
+		return err
+	}
+	summary, err := s.getCartSummary(cart)
+	if err != nil {
+		return err
+	}
+	if len(summary.Lines) == 0 {
+		return fmt.Errorf("cart is empty")
+	}
+	if err := checkoutBlocker(summary, req.AcceptPriceChanges); err != nil {
+		return err
+	}
+
+	order := types.NewOrder(summary)
+	order.ShippingAddress = shippingAddr
+	order.BillingAddress = billingAddr
+	if err := s.store.PlaceOrder(order, cart.CartID); err != nil {
+		if errors.Is(err, storage.ErrCheckoutConflict) {
+			return newAPIError(http.StatusConflict, "%v", err)
+		}
+		return err
+	}
+	if req.PaymentToken != "" {
+		if _, err := s.startPayment(order, req.PaymentToken, caller.ID); err != nil {
+			// the order stands, paying can be retried through /orders/{id}/pay
+			return newAPIError(http.StatusBadGateway, "order %d placed but payment failed: %v", order.ID, err)
+		}
+	}
+	return s.writeOrder(w, order.ID)
+}
+
+// checkoutAddress returns the caller's address with the given ID, or their
+// default address of kind if id is zero. It returns nil if there is none.
+func (s *Server) checkoutAddress(accID, id int, kind types.AddressKind) (*types.Address, error) {
+	if id == 0 {
+		return s.store.GetDefaultAddress(accID, kind)
+	}
+	return s.store.GetAddress(accID, id)
+}
+
+// checkoutBlocker returns the first cart warning that keeps the cart from
+// being ordered. Price changes only block until the customer accepts them.
+func checkoutBlocker(summary *types.CartSummary, acceptPriceChanges bool) error {
+	for _, line := range summary.Lines {
+		for _, warning := range line.Warnings {
+			if warning.Code == types.CartWarningPriceChanged && acceptPriceChanges {
+				continue
+			}
+			return newAPIError(http.StatusConflict, "%s", warning.Message)
+		}
+	}
+	return nil
+}
+
+// handleOrders lists the caller's orders. Admins see every order and can
+// filter by accID and status.
+func (s *Server) handleOrders(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	vars := r.URL.Query()
+	q := &types.OrderQuery{
+		AccID:  caller.ID,
+		Status: types.OrderStatus(vars.Get("status")),
+	}
+	if caller.canModerate() {
+		q.AccID = 0
+		if str := vars.Get("accID"); str != "" {
+			if q.AccID, err = strconv.Atoi(str); err != nil {
+				return fmt.Errorf("invalid accID %s", str)
+			}
+		}
+	}
+	list, err := s.store.GetOrders(q)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleOrderByID(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	order, err := s.getOwnOrder(caller, id)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, order)
+}
+
+// getOwnOrder returns the order if the caller placed it or is an admin.
+func (s *Server) getOwnOrder(caller *tokenAccount, id int) (*types.Order, error) {
+	order, err := s.store.GetOrderByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if order.AccID != caller.ID && !caller.canModerate() {
+		return nil, newAPIError(http.StatusForbidden, "permission denied")
+	}
+	return order, nil
+}
+
+// handleOrderStatus lets admins move an order through its lifecycle.
+func (s *Server) handleOrderStatus(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	req := new(types.OrderTransitionRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return err
+	}
+	if req.Status == "" {
+		return fmt.Errorf("empty status")
+	}
+	return s.transitionOrder(w, id, req.Status, caller.ID, truncate(req.Note, maxOrderNoteLength))
+}
+
+// handleCancelOrder lets customers cancel their orders until they are
+// being processed.
+func (s *Server) handleCancelOrder(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	order, err := s.getOwnOrder(caller, id)
+	if err != nil {
+		return err
+	}
+	if order.Status != types.OrderPendingPayment && order.Status != types.OrderPaid {
+		return newAPIError(http.StatusConflict, "order %d can no longer be cancelled", id)
+	}
+	return s.transitionOrder(w, id, types.OrderCancelled, caller.ID, "cancelled by customer")
+}
+
+const maxOrderNoteLength = 200
+
+func (s *Server) transitionOrder(w http.ResponseWriter, id int, to types.OrderStatus, actorID int, note string) error {
+	order, err := s.orderFlow.Transition(id, to, actorID, note)
+	if errors.Is(err, orders.ErrIllegalTransition) || errors.Is(err, storage.ErrOrderChanged) {
+		return newAPIError(http.StatusConflict, "%v", err)
+	}
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, order)
+}
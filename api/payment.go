@@ -0,0 +1,302 @@
+package api
+
+import (
+	"3legant/money"
+	"3legant/payments"
+	"3legant/storage"
+	"3legant/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	maxWebhookSize = 64 << 10
+	// paymentReleaseSettle is how long RetryPaymentReleases waits after an
+	// order was cancelled or refunded before taking over from the hook.
+	paymentReleaseSettle = 10 * time.Minute
+)
+
+// startPayment authorizes the order total with token. Approved payments are
+// captured right away; payments that need a 3-D Secure challenge finish
+// through the webhook.
+func (s *Server) startPayment(order *types.Order, token string, actorID int) (*types.Payment, error) {
+	intent, err := s.payments.Authorize(&payments.AuthorizeRequest{
+		OrderID: order.ID,
+		Amount:  order.Total,
+		Token:   token,
+	})
+	if err != nil {
+		// nothing was charged or stored yet
+		return nil, newAPIError(http.StatusServiceUnavailable, "payment could not be started: %v", err)
+	}
+	payment := &types.Payment{
+		OrderID:       order.ID,
+		IntentID:      intent.ID,
+		Status:        string(intent.Status),
+		Amount:        intent.Amount,
+		NextAction:    intent.NextAction,
+		DeclineReason: intent.DeclineReason,
+	}
+	if err := s.store.CreatePayment(payment); err != nil {
+		// another payment got there first, do not hold the customer's money
+		if intent.Status == payments.IntentAuthorized {
+			if voidErr := s.payments.Void(intent.ID); voidErr != nil {
+				log.Printf("void payment %s: %v", intent.ID, voidErr)
+			}
+		}
+		if errors.Is(err, storage.ErrPaymentInProgress) || errors.Is(err, storage.ErrOrderChanged) {
+			return nil, newAPIError(http.StatusConflict, "%v", err)
+		}
+		return nil, err
+	}
+	if intent.Status == payments.IntentAuthorized {
+		if err := s.capturePayment(payment, actorID); err != nil {
+			return nil, err
+		}
+	}
+	return payment, nil
+}
+
+// capturePayment captures an authorized payment and marks its order paid.
+// If the order cannot be marked paid, e.g. because it was cancelled or paid
+// by another payment meanwhile, the captured money is refunded.
+func (s *Server) capturePayment(payment *types.Payment, actorID int) error {
+	if err := s.payments.Capture(payment.IntentID); err != nil {
+		return err
+	}
+	captured := string(payments.IntentCaptured)
+	if err := s.store.UpdatePaymentStatus(payment.ID, payment.Status, captured, ""); err != nil {
+		return err
+	}
+	payment.Status = captured
+	_, err := s.orderFlow.Transition(payment.OrderID, types.OrderPaid, actorID, "payment "+payment.IntentID)
+	if err != nil {
+		if refundErr := s.refundPayment(payment, payment.Amount-payment.Refunded); refundErr != nil {
+			return fmt.Errorf("%v, and refunding payment %s failed: %v", err, payment.IntentID, refundErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// handlePayOrder starts a new payment for an order that is still awaiting
+// one, e.g. after a declined card.
+func (s *Server) handlePayOrder(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	order, err := s.getOwnOrder(caller, id)
+	if err != nil {
+		return err
+	}
+	if order.Status != types.OrderPendingPayment {
+		return newAPIError(http.StatusConflict, "order %d is %s", id, order.Status)
+	}
+	req := new(types.PayOrderRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return err
+	}
+	if req.Token == "" {
+		return fmt.Errorf("empty token")
+	}
+	if _, err := s.startPayment(order, req.Token, caller.ID); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		return newAPIError(http.StatusBadGateway, "payment failed: %v", err)
+	}
+	return s.writeOrder(w, id)
+}
+
+func (s *Server) writeOrder(w http.ResponseWriter, id int) error {
+	order, err := s.store.GetOrderByID(id)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, order)
+}
+
+// handlePaymentWebhook receives asynchronous payment outcomes from the
+// provider.
+func (s *Server) handlePaymentWebhook(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		return err
+	}
+	event, err := s.payments.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		return newAPIError(http.StatusUnauthorized, "%v", err)
+	}
+	payment, err := s.store.GetPaymentByIntent(event.IntentID)
+	if err != nil {
+		return err
+	}
+	// an authorized payment means an earlier delivery of the event stopped
+	// before capturing, so a retry finishes it
+	resume := payment.Status == string(payments.IntentAuthorized) && event.Type == payments.EventAuthorized
+	if payment.Status != string(payments.IntentRequiresAction) && !resume {
+		// already handled, providers deliver webhooks at least once
+		return WriteJSON(w, http.StatusOK, map[string]string{"ignored": event.IntentID})
+	}
+
+	switch event.Type {
+	case payments.EventAuthorized:
+		authorized := string(payments.IntentAuthorized)
+		if !resume {
+			if err := s.store.UpdatePaymentStatus(payment.ID, payment.Status, authorized, ""); err != nil {
+				return err
+			}
+			payment.Status = authorized
+		}
+		order, err := s.store.GetOrderByID(payment.OrderID)
+		if err != nil {
+			return err
+		}
+		if order.Status != types.OrderPendingPayment {
+			// the order was cancelled while the customer was authenticating
+			return s.voidPayment(payment)
+		}
+		if err := s.capturePayment(payment, 0); err != nil {
+			return err
+		}
+	case payments.EventFailed:
+		failed := string(payments.IntentFailed)
+		if err := s.store.UpdatePaymentStatus(payment.ID, payment.Status, failed, event.Reason); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown event %s", event.Type)
+	}
+	return WriteJSON(w, http.StatusOK, map[string]string{"received": event.IntentID})
+}
+
+func (s *Server) voidPayment(payment *types.Payment) error {
+	if err := s.payments.Void(payment.IntentID); err != nil {
+		return err
+	}
+	return s.store.UpdatePaymentStatus(payment.ID, payment.Status, string(payments.IntentVoided), "")
+}
+
+// releasePayments gives back the money of a cancelled or refunded order:
+// authorized payments are voided, captured ones refunded in full.
+func (s *Server) releasePayments(order *types.Order) error {
+	for _, payment := range order.Payments {
+		if payment.Status == string(payments.IntentAuthorized) {
+			if err := s.voidPayment(payment); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := s.refundPayments(order, refundable(order))
+	return err
+}
+
+// RetryPaymentReleases releases the payments of cancelled and refunded
+// orders whose releasePayments hook failed, e.g. because the provider was
+// down. Orders are left alone for a while after their last status change,
+// so the hook is not raced.
+func (s *Server) RetryPaymentReleases() error {
+	list, err := s.store.GetOrdersAwaitingRelease(paymentReleaseSettle)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, order := range list {
+		if err := s.releasePayments(order); err != nil {
+			log.Printf("release payments of order %d: %v", order.ID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("releasing the payments of %d of %d orders failed", failed, len(list))
+	}
+	return nil
+}
+
+// refundable returns how much of the order's captured payments has not been
+// refunded yet.
+func refundable(order *types.Order) money.Amount {
+	var total money.Amount
+	for _, payment := range order.Payments {
+		if payment.Status == string(payments.IntentCaptured) {
+			total += payment.Amount - payment.Refunded
+		}
+	}
+	return total
+}
+
+// refundPayments pays amount back, spread over the order's captured payments.
+// It returns how much was paid back, which is less than amount if it fails
+// part way.
+func (s *Server) refundPayments(order *types.Order, amount money.Amount) (money.Amount, error) {
+	var refunded money.Amount
+	for _, payment := range order.Payments {
+		if amount-refunded <= 0 {
+			break
+		}
+		if payment.Status != string(payments.IntentCaptured) {
+			continue
+		}
+		part := money.Min(payment.Amount-payment.Refunded, amount-refunded)
+		if part <= 0 {
+			continue
+		}
+		if err := s.refundPayment(payment, part); err != nil {
+			return refunded, err
+		}
+		refunded += part
+	}
+	if refunded < amount {
+		return refunded, fmt.Errorf("%s could not be refunded", amount-refunded)
+	}
+	return refunded, nil
+}
+
+// refundPayment pays amount of a captured payment back.
+func (s *Server) refundPayment(payment *types.Payment, amount money.Amount) error {
+	if err := s.payments.Refund(payment.IntentID, amount); err != nil {
+		return err
+	}
+	if err := s.store.AddPaymentRefund(payment.ID, amount); err != nil {
+		return err
+	}
+	payment.Refunded += amount
+	return nil
+}
+
+// handleFakeChallenge stands in for the bank's 3-D Secure page when the
+// fake gateway is used. result=fail fails the challenge.
+func (s *Server) handleFakeChallenge(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	fake, ok := s.payments.(*payments.FakeGateway)
+	if !ok {
+		return newAPIError(http.StatusNotFound, "not found")
+	}
+	intentID := mux.Vars(r)["intentID"]
+	pass := r.URL.Query().Get("result") != "fail"
+	if err := fake.CompleteChallenge(intentID, pass); err != nil {
+		log.Printf("fake challenge %s: %v", intentID, err)
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]bool{"passed": pass})
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"3legant/promotions"
+	"3legant/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (s *Server) handlePromotions(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		promos, err := s.store.GetPromotions()
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, promos)
+	}
+	if r.Method == "POST" {
+		promo, err := decodePromotion(r)
+		if err != nil {
+			return err
+		}
+		if err := s.store.CreatePromotion(promo); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, promo)
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func (s *Server) handlePromotionByID(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == "GET" {
+		promo, err := s.store.GetPromotionByID(id)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, promo)
+	}
+	if r.Method == "PUT" {
+		promo, err := decodePromotion(r)
+		if err != nil {
+			return err
+		}
+		if err := s.store.UpdatePromotion(id, promo); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"updated": id})
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.DeletePromotion(id); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"deleted": id})
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func decodePromotion(r *http.Request) (*types.Promotion, error) {
+	req := new(types.CreatePromotionRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, err
+	}
+	promo := types.NewPromotion(req)
+	promo.Code = promotions.NormalizeCode(promo.Code)
+	if err := promotions.Validate(promo); err != nil {
+		return nil, err
+	}
+	return promo, nil
+}
+
+func (s *Server) handleCartCoupon(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	cart, err := s.store.GetCartByUserID(id)
+	if err != nil {
+		return err
+	}
+	return s.serveCoupon(w, r, cart)
+}
+
+func (s *Server) handleGuestCartCoupon(w http.ResponseWriter, r *http.Request) error {
+	cart, err := s.getGuestCart(r)
+	if err != nil {
+		return err
+	}
+	if cart == nil {
+		return fmt.Errorf("cart is empty")
+	}
+	return s.serveCoupon(w, r, cart)
+}
+
+// serveCoupon applies (POST) or removes (DELETE) the cart's coupon and
+// returns the repriced cart.
+func (s *Server) serveCoupon(w http.ResponseWriter, r *http.Request, cart *types.Cart) error {
+	if r.Method == "POST" {
+		req := new(types.ApplyCouponRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+		code := promotions.NormalizeCode(req.Code)
+		if code == "" {
+			return fmt.Errorf("empty code")
+		}
+		promo, err := s.store.GetPromotionByCode(code)
+		if err != nil {
+			return err
+		}
+		summary, err := s.getCartSummary(cart)
+		if err != nil {
+			return err
+		}
+		discounter := &promotions.Discounter{Store: s.store}
+		if err := discounter.Check(promo, summary); err != nil {
+			return newAPIError(http.StatusUnprocessableEntity, "coupon %s cannot be applied: %v", code, err)
+		}
+		if err := s.store.SetCartCoupon(cart.CartID, promo.ID); err != nil {
+			return err
+		}
+	} else if r.Method == "DELETE" {
+		if err := s.store.DeleteCartCoupon(cart.CartID); err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	return s.handleGetCart(w, r, cart)
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"3legant/money"
+	"3legant/storage"
+	"3legant/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxReturnReasonLength = 200
+	maxReturnNoteLength   = 500
+)
+
+// handleOrderReturns lists the returns of an order and lets its customer
+// request a new one once the order was delivered.
+func (s *Server) handleOrderReturns(w http.ResponseWriter, r *http.Request) error {
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	order, err := s.getOwnOrder(caller, id)
+	if err != nil {
+		return err
+	}
+	if r.Method == "GET" {
+		return WriteJSON(w, http.StatusOK, order.Returns)
+	}
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	if order.AccID != caller.ID {
+		return newAPIError(http.StatusForbidden, "permission denied")
+	}
+	if order.Status != types.OrderDelivered {
+		return newAPIError(http.StatusConflict, "only delivered orders can be returned")
+	}
+	req := new(types.CreateReturnRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return err
+	}
+	if len(req.Lines) == 0 {
+		return fmt.Errorf("no lines to return")
+	}
+	seen := map[int]bool{}
+	for _, line := range req.Lines {
+		if line.Quantity < 1 {
+			return fmt.Errorf("quantity must be positive")
+		}
+		if seen[line.OrderLineID] {
+			return fmt.Errorf("order line %d is listed more than once", line.OrderLineID)
+		}
+		seen[line.OrderLineID] = true
+		line.Reason = truncate(strings.TrimSpace(line.Reason), maxReturnReasonLength)
+		if line.Reason == "" {
+			return fmt.Errorf("every returned line needs a reason")
+		}
+	}
+	ret := &types.Return{
+		OrderID: order.ID,
+		AccID:   caller.ID,
+		Status:  types.ReturnRequested,
+		Note:    truncate(req.Note, maxReturnNoteLength),
+		Lines:   req.Lines,
+	}
+	if err := s.store.CreateReturn(ret); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, ret)
+}
+
+func (s *Server) handleReturns(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	returns, err := s.store.GetReturns(types.ReturnStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, returns)
+}
+
+func (s *Server) handleReturnByID(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	ret, err := s.store.GetReturnByID(id)
+	if err != nil {
+		return err
+	}
+	if ret.AccID != caller.ID && !caller.canModerate() {
+		return newAPIError(http.StatusForbidden, "permission denied")
+	}
+	return WriteJSON(w, http.StatusOK, ret)
+}
+
+// handleApproveReturn accepts a return. The label is a placeholder reference
+// until return labels are bought from a carrier.
+func (s *Server) handleApproveReturn(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	label := fmt.Sprintf("RMA-%06d", id)
+	if err := returnConflict(s.store.ApproveReturn(id, label)); err != nil {
+		return err
+	}
+	return s.writeReturn(w, id)
+}
+
+func (s *Server) handleRejectReturn(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	req := new(types.RejectReturnRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return err
+	}
+	reason := truncate(strings.TrimSpace(req.Reason), maxReturnReasonLength)
+	if reason == "" {
+		return fmt.Errorf("empty reason")
+	}
+	if err := returnConflict(s.store.RejectReturn(id, reason)); err != nil {
+		return err
+	}
+	return s.writeReturn(w, id)
+}
+
+// handleReceiveReturn records that the goods arrived back and restocks them.
+func (s *Server) handleReceiveReturn(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if err := returnConflict(s.store.ReceiveReturn(id)); err != nil {
+		return err
+	}
+	return s.writeReturn(w, id)
+}
+
+// handleRefundReturn pays back a received return, by default the value of
+// the returned lines. Once the whole order is paid back it becomes refunded.
+func (s *Server) handleRefundReturn(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	req := new(types.RefundReturnRequest)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+	}
+	ret, err := s.store.GetReturnByID(id)
+	if err != nil {
+		return err
+	}
+	order, err := s.store.GetOrderByID(ret.OrderID)
+	if err != nil {
+		return err
+	}
+	// an earlier refund of the return may have paid back part of it
+	amount := returnValue(order, ret) - ret.RefundAmount
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	if amount > refundable(order) {
+		return fmt.Errorf("at most %s can be refunded", refundable(order))
+	}
+
+	if err := returnConflict(s.store.RefundReturn(id, amount)); err != nil {
+		return err
+	}
+	if refunded, err := s.refundPayments(order, amount); err != nil {
+		if revertErr := s.store.RevertReturnRefund(id, amount-refunded); revertErr != nil {
+			return fmt.Errorf("refund failed after paying back %s: %v, return %d still marked refunded: %v",
+				refunded, err, id, revertErr)
+		}
+		return newAPIError(http.StatusBadGateway, "refund failed after paying back %s: %v", refunded, err)
+	}
+	if refundable(order) == 0 && order.Status == types.OrderDelivered {
+		note := fmt.Sprintf("return %d", id)
+		if _, err := s.orderFlow.Transition(order.ID, types.OrderRefunded, caller.ID, note); err != nil {
+			return err
+		}
+	}
+	return s.writeReturn(w, id)
+}
+
+// returnValue is what the returned lines were paid, discounts and tax
+// included.
+func returnValue(order *types.Order, ret *types.Return) money.Amount {
+	var total money.Amount
+	for _, rl := range ret.Lines {
+		for _, line := range order.Lines {
+			if line.ID == rl.OrderLineID && line.Quantity > 0 {
+				paid := line.LineTotal
+				if !order.TaxIncluded {
+					paid += line.Tax
+				}
+				total += paid.Mul(rl.Quantity) / money.Amount(line.Quantity)
+			}
+		}
+	}
+	return total
+}
+
+func returnConflict(err error) error {
+	if errors.Is(err, storage.ErrReturnChanged) {
+		return newAPIError(http.StatusConflict, "%v", err)
+	}
+	return err
+}
+
+func (s *Server) writeReturn(w http.ResponseWriter, id int) error {
+	ret, err := s.store.GetReturnByID(id)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, ret)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"3legant/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	maxMerchantReplyLength = 1000
+	// reviews with this many open abuse reports go back to the moderation queue
+	reportThreshold = 3
+)
+
+func (s *Server) handleReviewVote(w http.ResponseWriter, r *http.Request) error {
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == "POST" {
+		review, err := s.getPublishedReview(id)
+		if err != nil {
+			return err
+		}
+		if review.AccID == caller.ID {
+			return newAPIError(http.StatusForbidden, "cannot vote on your own review")
+		}
+		req := new(types.ReviewVoteRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+		if err := s.store.VoteReview(id, caller.ID, req.Helpful); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"voted": id})
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.DeleteReviewVote(id, caller.ID); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"unvoted": id})
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func (s *Server) handleReviewReply(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == "PUT" {
+		req := new(types.ReviewReplyRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+		if len(req.Text) == 0 {
+			return fmt.Errorf("empty reply")
+		}
+		if len([]rune(req.Text)) > maxMerchantReplyLength {
+			return fmt.Errorf("reply must be at most %d characters", maxMerchantReplyLength)
+		}
+		if err := s.store.SetMerchantReply(id, req.Text); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"replied": id})
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.SetMerchantReply(id, ""); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"deleted": id})
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func (s *Server) handleReportReview(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.getPublishedReview(id); err != nil {
+		return err
+	}
+	req := new(types.ReportReviewRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return err
+	}
+	if len(req.Reason) == 0 {
+		return fmt.Errorf("empty reason")
+	}
+	report := &types.ReviewReport{
+		ReviewID: id,
+		AccID:    caller.ID,
+		Reason:   truncate(req.Reason, maxModerationReasonLength),
+	}
+	open, err := s.store.CreateReviewReport(report)
+	if err != nil {
+		return err
+	}
+	if open >= reportThreshold {
+		if err := s.store.SetReviewStatus(id, types.ReviewStatusPending, "reported by customers"); err != nil {
+			return err
+		}
+	}
+	return WriteJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) handleGetReviewReports(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	resolved := false
+	if str := r.URL.Query().Get("resolved"); str != "" {
+		var err error
+		if resolved, err = strconv.ParseBool(str); err != nil {
+			return fmt.Errorf("invalid resolved %s", str)
+		}
+	}
+	reports, err := s.store.GetReviewReports(resolved)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, reports)
+}
+
+func (s *Server) handleResolveReviewReport(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if err := s.store.ResolveReviewReport(id); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int{"resolved": id})
+}
+
+func (s *Server) getPublishedReview(id int) (*types.Review, error) {
+	review, err := s.store.GetReviewByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if review.Status != types.ReviewStatusApproved {
+		return nil, newAPIError(http.StatusNotFound, "review %d not found", id)
+	}
+	return review, nil
+}
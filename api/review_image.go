@@ -0,0 +1,276 @@
+package api
+
+import (
+	"3legant/blob"
+	"3legant/thumbnail"
+	"3legant/types"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	maxReviewImages    = 5
+	maxReviewImageSize = 5 << 20
+	// maxReviewImagePixels bounds the memory decoding an image takes, which
+	// the file size does not: a small file can hold a huge image.
+	maxReviewImagePixels = 40_000_000
+	thumbnailSize        = 240
+)
+
+func (s *Server) handleUploadReviewImages(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	review, err := s.store.GetReviewByID(id)
+	if err != nil {
+		return err
+	}
+	if review.AccID != caller.ID {
+		return newAPIError(http.StatusForbidden, "permission denied")
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxReviewImages*maxReviewImageSize+1<<20)
+	if err := r.ParseMultipartForm(maxReviewImageSize); err != nil {
+		return err
+	}
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		return fmt.Errorf("no images uploaded")
+	}
+	existing, err := s.store.CountReviewImages(id)
+	if err != nil {
+		return err
+	}
+	if existing+len(files) > maxReviewImages {
+		return fmt.Errorf("a review can have at most %d images", maxReviewImages)
+	}
+
+	images := []*types.ReviewImage{}
+	for _, fh := range files {
+		if fh.Size > maxReviewImageSize {
+			return fmt.Errorf("%s is larger than %d bytes", fh.Filename, maxReviewImageSize)
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		img, err := s.storeReviewImage(id, data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fh.Filename, err)
+		}
+		images = append(images, img)
+	}
+	return WriteJSON(w, http.StatusOK, images)
+}
+
+// storeReviewImage saves the image and its thumbnail in the blob store. The
+// image stays hidden from other customers until a moderator approves it.
+func (s *Server) storeReviewImage(reviewID int, data []byte) (*types.ReviewImage, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not a supported image")
+	}
+	if config.Width*config.Height > maxReviewImagePixels {
+		return nil, fmt.Errorf("image is larger than %d pixels", maxReviewImagePixels)
+	}
+	decoded, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not a supported image")
+	}
+	thumb, err := thumbnail.Make(decoded, thumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+	name, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	img := &types.ReviewImage{
+		ReviewID: reviewID,
+		Key:      fmt.Sprintf("reviews/%d/%s.%s", reviewID, name[:32], format),
+		ThumbKey: fmt.Sprintf("reviews/%d/%s_thumb.jpeg", reviewID, name[:32]),
+		Status:   types.ReviewStatusPending,
+	}
+	img.URL = s.blobs.URL(img.Key)
+	img.ThumbnailURL = s.blobs.URL(img.ThumbKey)
+	if err := s.blobs.Put(img.Key, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	if err := s.blobs.Put(img.ThumbKey, bytes.NewReader(thumb)); err != nil {
+		s.deleteBlobs(img)
+		return nil, err
+	}
+	if err := s.store.CreateReviewImage(img, maxReviewImages); err != nil {
+		s.deleteBlobs(img)
+		return nil, err
+	}
+	return img, nil
+}
+
+func (s *Server) handleDeleteReviewImage(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "DELETE" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	imageID, err := getIntVar(r, "imageID")
+	if err != nil {
+		return err
+	}
+	if _, err := s.getOwnReview(caller, id); err != nil {
+		return err
+	}
+	img, err := s.store.GetReviewImage(imageID)
+	if err != nil {
+		return err
+	}
+	if img.ReviewID != id {
+		return fmt.Errorf("image %d not found", imageID)
+	}
+	if err := s.store.DeleteReviewImage(imageID); err != nil {
+		return err
+	}
+	s.deleteBlobs(img)
+	return WriteJSON(w, http.StatusOK, map[string]int{"deleted": imageID})
+}
+
+func (s *Server) handleGetReviewImageQueue(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	images, err := s.store.GetReviewImagesByStatus(types.ReviewStatusPending)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, images)
+}
+
+func (s *Server) handleApproveReviewImage(w http.ResponseWriter, r *http.Request) error {
+	return s.setReviewImageStatus(w, r, types.ReviewStatusApproved)
+}
+
+func (s *Server) handleRejectReviewImage(w http.ResponseWriter, r *http.Request) error {
+	return s.setReviewImageStatus(w, r, types.ReviewStatusRejected)
+}
+
+func (s *Server) setReviewImageStatus(w http.ResponseWriter, r *http.Request, status types.ReviewStatus) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if err := s.store.SetReviewImageStatus(id, status); err != nil {
+		return err
+	}
+	if status == types.ReviewStatusRejected {
+		img, err := s.store.GetReviewImage(id)
+		if err != nil {
+			return err
+		}
+		s.deleteBlobs(img)
+	}
+	return WriteJSON(w, http.StatusOK, map[string]any{"id": id, "status": status})
+}
+
+// handleGetBlob serves review images from the blob store. Images that were
+// not approved yet are only served to the review's author and moderators.
+func (s *Server) handleGetBlob(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	key := mux.Vars(r)["key"]
+	if !strings.HasPrefix(key, "reviews/") {
+		// only review images are public, everything else has its own route
+		return newAPIError(http.StatusNotFound, "not found")
+	}
+	if !s.canSeeReviewImage(r, key) {
+		return newAPIError(http.StatusNotFound, "not found")
+	}
+	rc, err := s.blobs.Get(key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return newAPIError(http.StatusNotFound, "not found")
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if ctype := mime.TypeByExtension(path.Ext(key)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+func (s *Server) canSeeReviewImage(r *http.Request, key string) bool {
+	img, err := s.store.GetReviewImageByKey(key)
+	if err != nil {
+		return false
+	}
+	if img.Status == types.ReviewStatusApproved {
+		return true
+	}
+	caller, err := getTokenAccount(r)
+	if err != nil {
+		return false
+	}
+	if caller.canModerate() {
+		return true
+	}
+	review, err := s.store.GetReviewByID(img.ReviewID)
+	return err == nil && review.AccID == caller.ID
+}
+
+// approvedImages returns the images a moderator approved, the only ones
+// other customers may see.
+func approvedImages(images []*types.ReviewImage) []*types.ReviewImage {
+	approved := []*types.ReviewImage{}
+	for _, img := range images {
+		if img.Status == types.ReviewStatusApproved {
+			approved = append(approved, img)
+		}
+	}
+	return approved
+}
+
+func (s *Server) deleteBlobs(images ...*types.ReviewImage) {
+	for _, img := range images {
+		for _, key := range []string{img.Key, img.ThumbKey} {
+			if err := s.blobs.Delete(key); err != nil {
+				log.Printf("delete blob %s: %v", key, err)
+			}
+		}
+	}
+}
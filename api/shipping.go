@@ -0,0 +1,85 @@
+package api
+
+import (
+	"3legant/shipping"
+	"3legant/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func (s *Server) handleCartShipping(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	cart, err := s.store.GetCartByUserID(id)
+	if err != nil {
+		return err
+	}
+	return s.serveShipping(w, r, cart)
+}
+
+func (s *Server) handleGuestCartShipping(w http.ResponseWriter, r *http.Request) error {
+	cart, err := s.getGuestCart(r)
+	if err != nil {
+		return err
+	}
+	if cart == nil {
+		return fmt.Errorf("cart is empty")
+	}
+	return s.serveShipping(w, r, cart)
+}
+
+// serveShipping quotes the shipping methods for the cart (GET) or stores the
+// customer's choice (PUT). GET quotes the cart's own destination unless
+// country, region and postalCode are given.
+func (s *Server) serveShipping(w http.ResponseWriter, r *http.Request, cart *types.Cart) error {
+	if r.Method == "GET" {
+		dest := cart.Destination
+		if country := r.URL.Query().Get("country"); country != "" {
+			dest = normalizeDestination(country, r.URL.Query().Get("region"), r.URL.Query().Get("postalCode"))
+		}
+		summary, err := s.getCartSummary(cart)
+		if err != nil {
+			return err
+		}
+		quotes, err := s.shipping.Quote(summary, dest)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, quotes)
+	}
+	if r.Method == "PUT" {
+		req := new(types.SelectShippingRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+		dest := normalizeDestination(req.Country, req.Region, req.PostalCode)
+		if len(dest.Country) != 2 {
+			return fmt.Errorf("country must be a two letter code")
+		}
+		if req.Method == "" {
+			req.Method = shipping.MethodStandard
+		}
+		if err := s.shipping.Check(dest, req.Method); err != nil {
+			return err
+		}
+		if err := s.store.SetCartShipping(cart.CartID, dest, req.Method); err != nil {
+			return err
+		}
+		cart.Destination = dest
+		cart.ShippingMethod = req.Method
+		return s.handleGetCart(w, r, cart)
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func normalizeDestination(country, region, postalCode string) types.Destination {
+	return types.Destination{
+		Country:    strings.ToUpper(strings.TrimSpace(country)),
+		Region:     strings.TrimSpace(region),
+		PostalCode: strings.TrimSpace(postalCode),
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"3legant/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		subs, err := s.store.GetWebhookSubscriptions()
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			sub.Secret = ""
+		}
+		return WriteJSON(w, http.StatusOK, subs)
+	}
+	if r.Method == "POST" {
+		sub, err := decodeWebhookSubscription(r)
+		if err != nil {
+			return err
+		}
+		if sub.Secret == "" {
+			if sub.Secret, err = newRandomToken(); err != nil {
+				return err
+			}
+		}
+		if err := s.store.CreateWebhookSubscription(sub); err != nil {
+			return err
+		}
+		// the secret is only ever shown when the subscription is created
+		return WriteJSON(w, http.StatusOK, sub)
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func (s *Server) handleWebhookByID(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == "GET" {
+		sub, err := s.store.GetWebhookSubscription(id)
+		if err != nil {
+			return err
+		}
+		sub.Secret = ""
+		return WriteJSON(w, http.StatusOK, sub)
+	}
+	if r.Method == "PUT" {
+		sub, err := decodeWebhookSubscription(r)
+		if err != nil {
+			return err
+		}
+		sub.ID = id
+		if err := s.store.UpdateWebhookSubscription(sub); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"updated": id})
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.DeleteWebhookSubscription(id); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"deleted": id})
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+// handleWebhookDeliveries lists the latest deliveries of a subscription,
+// optionally only those with ?status=.
+func (s *Server) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.store.GetWebhookSubscription(id); err != nil {
+		return err
+	}
+	deliveries, err := s.store.GetWebhookDeliveries(id, types.DeliveryStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, deliveries)
+}
+
+// handleReplayDelivery queues the event of a delivery to be sent again.
+func (s *Server) handleReplayDelivery(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	delivery, err := s.store.ReplayDelivery(id)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, delivery)
+}
+
+func decodeWebhookSubscription(r *http.Request) (*types.WebhookSubscription, error) {
+	req := new(types.WebhookSubscriptionRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if len(req.URL) > 500 {
+		return nil, fmt.Errorf("url must be at most 500 characters")
+	}
+	if len(req.Events) == 0 {
+		return nil, fmt.Errorf("subscribe to at least one event")
+	}
+	for _, e := range req.Events {
+		if !validEventType(e) {
+			return nil, fmt.Errorf("unknown event %s", e)
+		}
+	}
+	if len(req.Secret) > 100 {
+		return nil, fmt.Errorf("secret must be at most 100 characters")
+	}
+	sub := &types.WebhookSubscription{
+		URL:    req.URL,
+		Events: req.Events,
+		Secret: req.Secret,
+		Active: true,
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+	return sub, nil
+}
+
+func validEventType(e types.EventType) bool {
+	if e == types.EventAll {
+		return true
+	}
+	for _, known := range types.EventTypes {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
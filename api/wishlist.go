@@ -0,0 +1,213 @@
+package api
+
+import (
+	"3legant/types"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func (s *Server) handleWishlists(w http.ResponseWriter, r *http.Request) error {
+	accID, err := getID(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == "GET" {
+		wishlists, err := s.store.GetWishlistsByAccountID(accID)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, wishlists)
+	}
+	if r.Method == "POST" {
+		req := new(types.CreateWishlistRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+		if err := validateWishlistName(req.Name); err != nil {
+			return err
+		}
+		wishlist := types.NewWishlist(accID, req.Name)
+		if err := s.store.CreateWishlist(wishlist); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, wishlist)
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func (s *Server) handleWishlistByID(w http.ResponseWriter, r *http.Request) error {
+	accID, wishlistID, err := getWishlistIDs(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == "GET" {
+		wishlist, err := s.store.GetWishlist(accID, wishlistID)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, wishlist)
+	}
+	if r.Method == "PUT" {
+		req := new(types.CreateWishlistRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+		if err := validateWishlistName(req.Name); err != nil {
+			return err
+		}
+		if err := s.store.UpdateWishlist(accID, wishlistID, req.Name); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"updated": wishlistID})
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.DeleteWishlist(accID, wishlistID); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"deleted": wishlistID})
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func (s *Server) handleAddProductToWishlist(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	accID, wishlistID, err := getWishlistIDs(r)
+	if err != nil {
+		return err
+	}
+	req := new(types.WishlistItemRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return err
+	}
+	if _, err := s.store.GetProductByID(req.ProdID); err != nil {
+		return err
+	}
+	if req.FromCart {
+		err = s.store.MoveCartProductToWishlist(accID, wishlistID, req.ProdID)
+	} else {
+		err = s.store.AddProductToWishlist(accID, wishlistID, req.ProdID)
+	}
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int{"added": req.ProdID})
+}
+
+func (s *Server) handleDeleteProductFromWishlist(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "DELETE" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	accID, wishlistID, err := getWishlistIDs(r)
+	if err != nil {
+		return err
+	}
+	prodID, err := getIntVar(r, "prodID")
+	if err != nil {
+		return err
+	}
+	if err := s.store.DeleteProductFromWishlist(accID, wishlistID, prodID); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int{"deleted": prodID})
+}
+
+func (s *Server) handleMoveWishlistProductToCart(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	accID, wishlistID, err := getWishlistIDs(r)
+	if err != nil {
+		return err
+	}
+	prodID, err := getIntVar(r, "prodID")
+	if err != nil {
+		return err
+	}
+	req := &types.MoveToCartRequest{Quantity: 1}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return err
+		}
+	}
+	if req.Quantity < 1 {
+		return fmt.Errorf("quantity must be positive")
+	}
+	if err := s.store.MoveWishlistProductToCart(accID, wishlistID, prodID, req.Quantity); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int{"moved": prodID})
+}
+
+func (s *Server) handleShareWishlist(w http.ResponseWriter, r *http.Request) error {
+	accID, wishlistID, err := getWishlistIDs(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == "POST" {
+		token, err := newRandomToken()
+		if err != nil {
+			return err
+		}
+		if err := s.store.SetWishlistShareToken(accID, wishlistID, token); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]string{"shareToken": token})
+	}
+	if r.Method == "DELETE" {
+		if err := s.store.SetWishlistShareToken(accID, wishlistID, ""); err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, map[string]int{"unshared": wishlistID})
+	}
+	return fmt.Errorf("method not allowed %s", r.Method)
+}
+
+func (s *Server) handleGetSharedWishlist(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return fmt.Errorf("method not allowed %s", r.Method)
+	}
+	wishlist, err := s.store.GetWishlistByShareToken(mux.Vars(r)["token"])
+	if err != nil {
+		return err
+	}
+	// shared links are read-only and must not reveal who owns the list
+	wishlist.AccID = 0
+	wishlist.ShareToken = ""
+	return WriteJSON(w, http.StatusOK, wishlist)
+}
+
+func getWishlistIDs(r *http.Request) (int, int, error) {
+	accID, err := getID(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	wishlistID, err := getIntVar(r, "wishlistID")
+	if err != nil {
+		return 0, 0, err
+	}
+	return accID, wishlistID, nil
+}
+
+func validateWishlistName(name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("empty wishlist name")
+	}
+	if len(name) > 50 {
+		return fmt.Errorf("wishlist name too long")
+	}
+	return nil
+}
+
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,234 @@
+package invoice
+
+import (
+	"3legant/money"
+	"3legant/types"
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// Issuer is the business the invoices are issued by.
+type Issuer struct {
+	Name    string
+	Address []string
+	TaxID   string
+}
+
+// Document is everything printed on an invoice.
+type Document struct {
+	Issuer   *Issuer
+	Invoice  *types.Invoice
+	Order    *types.Order
+	Customer *types.Account
+}
+
+func (d *Document) Filename(ext string) string {
+	return d.Invoice.Number() + "." + ext
+}
+
+// billTo is the block the invoice is addressed to: the billing address of
+// the order or, for orders placed without one, the customer's name.
+func (d *Document) billTo() []string {
+	if addr := d.Order.BillingAddress; addr != nil {
+		return addressLines(addr)
+	}
+	lines := []string{}
+	if d.Customer != nil {
+		lines = append(lines, strings.TrimSpace(d.Customer.FirstName+" "+d.Customer.LastName), d.Customer.Email)
+	}
+	return lines
+}
+
+func (d *Document) shipTo() []string {
+	if addr := d.Order.ShippingAddress; addr != nil {
+		return addressLines(addr)
+	}
+	return nil
+}
+
+func addressLines(addr *types.Address) []string {
+	lines := []string{addr.Name, addr.Line1}
+	if addr.Line2 != "" {
+		lines = append(lines, addr.Line2)
+	}
+	city := strings.TrimSpace(strings.Join([]string{addr.PostalCode, addr.City, addr.Region}, " "))
+	return append(lines, city, addr.Country)
+}
+
+// total is a labelled amount below the invoice lines.
+type total struct {
+	Label  string
+	Amount money.Amount
+	Bold   bool
+}
+
+func (d *Document) totals() []total {
+	order := d.Order
+	totals := []total{{Label: "Subtotal", Amount: order.Subtotal}}
+	for _, adj := range order.Discounts {
+		label := adj.Description
+		if adj.Code != "" {
+			label += " (" + adj.Code + ")"
+		}
+		totals = append(totals, total{Label: label, Amount: -adj.Amount})
+	}
+	totals = append(totals, total{Label: "Shipping (" + order.ShippingMethod + ")", Amount: order.Shipping})
+	for _, t := range order.Taxes {
+		label := fmt.Sprintf("%s on %s", t.Name, t.Taxable)
+		if order.TaxIncluded {
+			label = "incl. " + label
+		}
+		totals = append(totals, total{Label: label, Amount: t.Amount})
+	}
+	return append(totals, total{Label: "Total", Amount: order.Total, Bold: true})
+}
+
+var htmlTemplate = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.Doc.Invoice.Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 4px 8px; text-align: left; }
+td.num, th.num { text-align: right; }
+thead th { border-bottom: 1px solid #000; }
+.bold { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Invoice {{.Doc.Invoice.Number}}</h1>
+<p>{{.Doc.Issuer.Name}}{{range .Doc.Issuer.Address}}<br>{{.}}{{end}}{{if .Doc.Issuer.TaxID}}<br>Tax ID: {{.Doc.Issuer.TaxID}}{{end}}</p>
+<p>Date: {{.Doc.Invoice.IssuedAt.Format "2006-01-02"}}<br>Order: #{{.Doc.Order.ID}}</p>
+<h2>Bill to</h2>
+<p>{{range $i, $l := .BillTo}}{{if $i}}<br>{{end}}{{$l}}{{end}}</p>
+{{if .ShipTo}}<h2>Ship to</h2>
+<p>{{range $i, $l := .ShipTo}}{{if $i}}<br>{{end}}{{$l}}{{end}}</p>{{end}}
+<table>
+<thead><tr><th>Item</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Discount</th><th class="num">Amount</th></tr></thead>
+<tbody>
+{{range .Doc.Order.Lines}}<tr><td>{{.Name}}</td><td class="num">{{.Quantity}}</td><td class="num">{{.UnitPrice}}</td><td class="num">{{.Discount}}</td><td class="num">{{.LineTotal}}</td></tr>
+{{end}}</tbody>
+<tfoot>
+{{range .Totals}}<tr{{if .Bold}} class="bold"{{end}}><td colspan="4" class="num">{{.Label}}</td><td class="num">{{.Amount}}</td></tr>
+{{end}}</tfoot>
+</table>
+{{if .Doc.Order.TaxIncluded}}<p>Prices include tax.</p>{{end}}
+</body>
+</html>
+`))
+
+func HTML(doc *Document) ([]byte, error) {
+	var b bytes.Buffer
+	err := htmlTemplate.Execute(&b, map[string]any{
+		"Doc":    doc,
+		"BillTo": doc.billTo(),
+		"ShipTo": doc.shipTo(),
+		"Totals": doc.totals(),
+	})
+	return b.Bytes(), err
+}
+
+const (
+	margin   = 50.0
+	fontSize = 9.0
+	leading  = 13.0
+)
+
+// PDF renders the invoice as a PDF, continuing on new pages when the lines
+// do not fit on one.
+func PDF(doc *Document) ([]byte, error) {
+	w := newPDFWriter()
+	y := pageHeight - margin
+	next := func() {
+		y -= leading
+		if y < margin {
+			w.newPage()
+			y = pageHeight - margin
+		}
+	}
+	right := pageWidth - margin
+
+	w.text(margin, y, 18, true, "Invoice "+doc.Invoice.Number())
+	y -= 2 * leading
+	issuer := append([]string{doc.Issuer.Name}, doc.Issuer.Address...)
+	if doc.Issuer.TaxID != "" {
+		issuer = append(issuer, "Tax ID: "+doc.Issuer.TaxID)
+	}
+	for _, l := range issuer {
+		w.text(margin, y, fontSize, false, l)
+		next()
+	}
+	next()
+	w.text(margin, y, fontSize, false, "Date:  "+doc.Invoice.IssuedAt.Format("2006-01-02"))
+	next()
+	w.text(margin, y, fontSize, false, fmt.Sprintf("Order: #%d", doc.Order.ID))
+	next()
+	next()
+
+	// bill to and ship to side by side
+	billTo, shipTo := doc.billTo(), doc.shipTo()
+	w.text(margin, y, fontSize, true, "Bill to")
+	if shipTo != nil {
+		w.text(pageWidth/2, y, fontSize, true, "Ship to")
+	}
+	next()
+	for i := 0; i < len(billTo) || i < len(shipTo); i++ {
+		if i < len(billTo) {
+			w.text(margin, y, fontSize, false, billTo[i])
+		}
+		if i < len(shipTo) {
+			w.text(pageWidth/2, y, fontSize, false, shipTo[i])
+		}
+		next()
+	}
+	next()
+
+	columns := []float64{right - 270, right - 180, right - 90, right}
+	header := func() {
+		w.text(margin, y, fontSize, true, "Item")
+		for i, h := range []string{"Qty", "Unit price", "Discount", "Amount"} {
+			w.textRight(columns[i], y, fontSize, true, h)
+		}
+		w.line(margin, y-4, right, y-4)
+		next()
+	}
+	header()
+	nameWidth := int((columns[0] - 40 - margin) / (charWidth * fontSize))
+	for _, line := range doc.Order.Lines {
+		pageBefore := len(w.pages)
+		w.text(margin, y, fontSize, false, clip(line.Name, nameWidth))
+		for i, v := range []string{fmt.Sprint(line.Quantity), line.UnitPrice.String(), line.Discount.String(), line.LineTotal.String()} {
+			w.textRight(columns[i], y, fontSize, false, v)
+		}
+		next()
+		if len(w.pages) != pageBefore {
+			header()
+		}
+	}
+	w.line(margin, y+leading-4, right, y+leading-4)
+	next()
+
+	for _, t := range doc.totals() {
+		w.textRight(columns[2], y, fontSize, t.Bold, t.Label)
+		w.textRight(columns[3], y, fontSize, t.Bold, t.Amount.String())
+		next()
+	}
+	if doc.Order.TaxIncluded {
+		next()
+		w.text(margin, y, fontSize, false, "Prices include tax.")
+	}
+	return w.bytes(), nil
+}
+
+// clip shortens str to n runes, marking the cut with an ellipsis.
+func clip(str string, n int) string {
+	runes := []rune(str)
+	if len(runes) <= n {
+		return str
+	}
+	return string(runes[:n-3]) + "..."
+}
@@ -0,0 +1,25 @@
+package jobs
+
+import (
+	"log"
+	"time"
+)
+
+// Schedule runs job in the background once immediately and then every
+// interval. Errors are logged and do not stop the schedule.
+func Schedule(name string, interval time.Duration, job func() error) {
+	go func() {
+		run(name, job)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(name, job)
+		}
+	}()
+}
+
+func run(name string, job func() error) {
+	if err := job(); err != nil {
+		log.Printf("job %s failed: %v", name, err)
+	}
+}
@@ -1,12 +1,26 @@
 package main
 
 import (
+	"3legant/abandoned"
 	"3legant/api"
+	"3legant/blob"
+	"3legant/invoice"
+	"3legant/jobs"
+	"3legant/mailer"
+	"3legant/money"
+	"3legant/payments"
+	"3legant/pricing"
+	"3legant/promotions"
+	"3legant/shipping"
 	"3legant/storage"
+	"3legant/tax"
 	"3legant/types"
+	"3legant/webhooks"
 	"flag"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 )
 
 func seedAccount(store storage.Storage, fname, lname, email, pw string, userType types.UserType) *types.Account {
@@ -28,6 +42,25 @@ func seedAccounts(store storage.Storage) {
 
 func main() {
 	seed := flag.Bool("seed", false, "seed the db")
+	relationsInterval := flag.Duration("relations-interval", time.Hour, "how often related products are recomputed")
+	mailDir := flag.String("mail-dir", "mail", "directory outgoing emails are written to")
+	mailFrom := flag.String("mail-from", "shop@3legant.com", "sender address of outgoing emails")
+	abandonedAfter := flag.Duration("abandoned-cart-after", abandoned.DefaultIdle, "how long a cart must be idle before its owner is reminded")
+	reminderInterval := flag.Duration("abandoned-cart-interval", time.Hour, "how often abandoned carts are looked for")
+	blobDir := flag.String("blob-dir", "uploads", "directory uploaded files are stored in")
+	taxRate := flag.Int("tax-rate", 0, "tax rate in basis points (2000 = 20%) where no tax rule applies")
+	taxRules := flag.String("tax-rules", "", "tax rules as country[/region][:class]=basis points, comma separated, e.g. US/CA=725,DE=1900,DE:reduced=700")
+	taxInclusive := flag.Bool("tax-inclusive", false, "product prices include tax")
+	taxRounding := flag.String("tax-rounding", string(tax.RoundPerLine), "round tax per line or per total")
+	shippingFee := flag.String("shipping-fee", "49.00", "base fee of domestic standard delivery")
+	freeShippingOver := flag.String("free-shipping-over", "500.00", "cart subtotal from which domestic standard delivery is free, 0 to disable")
+	homeCountry := flag.String("home-country", "US", "country orders ship from, assumed for carts without a destination")
+	publicURL := flag.String("public-url", "http://localhost:3000", "URL the API is reachable at, used for payment callbacks")
+	sellerName := flag.String("seller-name", "3legant", "business name printed on invoices")
+	sellerAddress := flag.String("seller-address", "", "business address printed on invoices, lines separated by ;")
+	sellerTaxID := flag.String("seller-tax-id", "", "VAT or tax ID printed on invoices")
+	webhookInterval := flag.Duration("webhook-interval", 5*time.Second, "how often outgoing webhooks are sent")
+	paymentSecret := flag.String("payment-webhook-secret", "fake-webhook-secret", "secret payment webhooks are signed with")
 	flag.Parse()
 	store, err := storage.NewPostgresStore()
 	if err != nil {
@@ -44,6 +77,73 @@ func main() {
 		seedAccounts(store)
 	}
 
-	server := api.NewAPIServer(":3000", store)
+	jobs.Schedule("product relations", *relationsInterval, store.RefreshProductRelations)
+	jobs.Schedule("guest cart cleanup", 24*time.Hour, func() error {
+		return store.DeleteExpiredGuestCarts(30 * 24 * time.Hour)
+	})
+	jobs.Schedule("idempotency key cleanup", time.Hour, store.DeleteExpiredIdempotencyKeys)
+	jobs.Schedule("webhook deliveries", *webhookInterval, webhooks.NewDispatcher(store).Run)
+
+	fileMailer, err := mailer.NewFileMailer(*mailDir, *mailFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reminder := &abandoned.Reminder{Store: store, Mailer: fileMailer, Idle: *abandonedAfter}
+	jobs.Schedule("abandoned cart reminders", *reminderInterval, reminder.Run)
+
+	blobs, err := blob.NewFSStore(*blobDir, "/blobs")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fee, err := money.Parse(*shippingFee)
+	if err != nil {
+		log.Fatal(err)
+	}
+	freeOver, err := money.Parse(*freeShippingOver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	home := types.Destination{Country: strings.ToUpper(*homeCountry)}
+	shippingRates := &shipping.Calculator{
+		Zones: shipping.DefaultZones(home, fee, freeOver),
+		Home:  home,
+	}
+	rules, err := tax.ParseRules(*taxRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *taxRate > 0 {
+		rules = append(rules, tax.Rule{RateBP: *taxRate})
+	}
+	rounding, err := tax.ParseRounding(*taxRounding)
+	if err != nil {
+		log.Fatal(err)
+	}
+	taxes := &tax.Calculator{
+		Rules:     rules,
+		Inclusive: *taxInclusive,
+		Rounding:  rounding,
+		Home:      home,
+	}
+	engine := &pricing.Engine{
+		Discounters: []pricing.Discounter{&promotions.Discounter{Store: store}},
+		Tax:         taxes,
+		Shipping:    shippingRates,
+	}
+
+	gateway := payments.NewFakeGateway(*paymentSecret, *publicURL+"/payments/webhook",
+		*publicURL+"/payments/fake/challenge/")
+
+	issuer := &invoice.Issuer{Name: *sellerName, TaxID: *sellerTaxID}
+	for _, line := range strings.Split(*sellerAddress, ";") {
+		if line = strings.TrimSpace(line); line != "" {
+			issuer.Address = append(issuer.Address, line)
+		}
+	}
+
+	server := api.NewAPIServer(":3000", store, fileMailer, blobs, engine, gateway, shippingRates, issuer)
+	jobs.Schedule("payment releases", 15*time.Minute, server.RetryPaymentReleases)
 	server.Run()
 }
@@ -0,0 +1,132 @@
+package moderation
+
+import (
+	"3legant/types"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Screener inspects a review before it is published. It returns a non-empty
+// reason when the review should be held for a moderator.
+type Screener interface {
+	Screen(*types.Review) (string, error)
+}
+
+// Pipeline runs every screener and queues the review if any of them flags it.
+type Pipeline []Screener
+
+// Screen returns the status the review should get and the reasons it was
+// queued, if any.
+func (p Pipeline) Screen(review *types.Review) (types.ReviewStatus, []string, error) {
+	reasons := []string{}
+	for _, screener := range p {
+		reason, err := screener.Screen(review)
+		if err != nil {
+			return "", nil, err
+		}
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) > 0 {
+		return types.ReviewStatusPending, reasons, nil
+	}
+	return types.ReviewStatusApproved, reasons, nil
+}
+
+var DefaultWords = []string{"fuck", "shit", "bitch", "cunt", "asshole", "bastard"}
+
+// WordListScreener flags reviews containing any of Words.
+type WordListScreener struct {
+	Words []string
+}
+
+var wordSplit = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+func (s *WordListScreener) Screen(review *types.Review) (string, error) {
+	words := map[string]bool{}
+	for _, w := range wordSplit.Split(strings.ToLower(review.Text), -1) {
+		words[w] = true
+	}
+	for _, w := range s.Words {
+		if words[strings.ToLower(w)] {
+			return "contains blocked words", nil
+		}
+	}
+	return "", nil
+}
+
+// SpamScreener flags reviews that look like spam: links, long runs of a
+// single character or shouting.
+type SpamScreener struct {
+	MaxLinks int
+}
+
+var (
+	linkPattern = regexp.MustCompile(`(?i)(https?://|www\.|\b[a-z0-9-]+\.(com|net|org|ru|io|biz|info)\b)`)
+	repeatRun   = 8
+)
+
+func (s *SpamScreener) Screen(review *types.Review) (string, error) {
+	if len(linkPattern.FindAllString(review.Text, -1)) > s.MaxLinks {
+		return "contains links", nil
+	}
+	run, prev := 0, rune(0)
+	letters, upper := 0, 0
+	for _, c := range review.Text {
+		if c == prev {
+			run++
+		} else {
+			run, prev = 1, c
+		}
+		if run >= repeatRun {
+			return "repeated characters", nil
+		}
+		if unicode.IsLetter(c) {
+			letters++
+			if unicode.IsUpper(c) {
+				upper++
+			}
+		}
+	}
+	if letters >= 20 && upper*10 >= letters*8 {
+		return "mostly capital letters", nil
+	}
+	return "", nil
+}
+
+// ReviewCounter counts the reviews an account wrote since the given time.
+type ReviewCounter interface {
+	CountReviewsSince(int, time.Time) (int, error)
+}
+
+// RateScreener flags reviews from accounts that post more than Limit reviews
+// within Window.
+type RateScreener struct {
+	Counter ReviewCounter
+	Limit   int
+	Window  time.Duration
+}
+
+func (s *RateScreener) Screen(review *types.Review) (string, error) {
+	n, err := s.Counter.CountReviewsSince(review.AccID, time.Now().Add(-s.Window))
+	if err != nil {
+		return "", err
+	}
+	if n >= s.Limit {
+		return fmt.Sprintf("more than %d reviews in %s", s.Limit, s.Window), nil
+	}
+	return "", nil
+}
+
+// DefaultPipeline is the screening used by the API server.
+func DefaultPipeline(counter ReviewCounter) Pipeline {
+	return Pipeline{
+		&WordListScreener{Words: DefaultWords},
+		&SpamScreener{MaxLinks: 0},
+		&RateScreener{Counter: counter, Limit: 5, Window: time.Hour},
+	}
+}
@@ -0,0 +1,168 @@
+package money
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Amount is a sum of money in cents. All cart and order arithmetic is done on
+// Amounts so totals never suffer from floating point drift.
+type Amount int64
+
+// FromFloat converts a price as stored on products to an Amount, rounding to
+// the nearest cent.
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * 100))
+}
+
+// Parse reads a decimal string such as "12.5" or "-3.99".
+func Parse(str string) (Amount, error) {
+	input := strings.TrimSpace(str)
+	digits, neg := strings.CutPrefix(input, "-")
+	whole, frac, _ := strings.Cut(digits, ".")
+	if !isDigits(whole) || !isDigits(frac) || whole+frac == "" {
+		return 0, fmt.Errorf("invalid amount %q", input)
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("invalid amount %q: more than two decimals", input)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("invalid amount %q: out of range", input)
+	}
+	f, _ := strconv.ParseInt(frac, 10, 64)
+	a := Amount(w*100 + f)
+	if neg {
+		a = -a
+	}
+	return a, nil
+}
+
+func isDigits(str string) bool {
+	for _, c := range str {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (a Amount) Mul(n int) Amount {
+	return a * Amount(n)
+}
+
+// MulRate returns a * bp / 10000 rounded half away from zero. Rates are given
+// in basis points, so 2000 is 20%.
+func (a Amount) MulRate(bp int) Amount {
+	p := int64(a) * int64(bp)
+	q, r := p/10000, p%10000
+	if r*2 >= 10000 {
+		q++
+	} else if r*2 <= -10000 {
+		q--
+	}
+	return Amount(q)
+}
+
+// IncludedTax returns the tax contained in a gross amount a at rate bp,
+// rounded half away from zero. At 2000 bp, 120.00 contains 20.00.
+func (a Amount) IncludedTax(bp int) Amount {
+	d := int64(10000 + bp)
+	p := int64(a) * 10000
+	net, r := p/d, p%d
+	if r*2 >= d {
+		net++
+	} else if r*2 <= -d {
+		net--
+	}
+	return a - Amount(net)
+}
+
+// Split divides a into parts proportional to weights. The parts always add
+// up to a exactly: the cents lost to rounding go one each to the parts with
+// the largest remainders, earlier parts first on ties. Weights that are not
+// positive get nothing, so if none is positive there is nothing to split a
+// over and every part is zero.
+func (a Amount) Split(weights []Amount) []Amount {
+	parts := make([]Amount, len(weights))
+	var total int64
+	for _, w := range weights {
+		if w > 0 {
+			total += int64(w)
+		}
+	}
+	if total == 0 {
+		return parts
+	}
+	remainders := make([]int64, len(weights))
+	eligible := []int{}
+	var assigned Amount
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		p := int64(a) * int64(w)
+		parts[i] = Amount(p / total)
+		remainders[i] = p % total
+		if remainders[i] < 0 {
+			remainders[i] = -remainders[i]
+		}
+		assigned += parts[i]
+		eligible = append(eligible, i)
+	}
+	sort.SliceStable(eligible, func(x, y int) bool {
+		return remainders[eligible[x]] > remainders[eligible[y]]
+	})
+	step := Amount(1)
+	if a < 0 {
+		step = -1
+	}
+	for _, i := range eligible {
+		if assigned == a {
+			break
+		}
+		parts[i] += step
+		assigned += step
+	}
+	return parts
+}
+
+func Min(a, b Amount) Amount {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (a Amount) Float() float64 {
+	return float64(a) / 100
+}
+
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign, a = "-", -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, a/100, a%100)
+}
+
+// MarshalJSON encodes the amount as a number with exactly two decimals.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	v, err := Parse(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
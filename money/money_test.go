@@ -0,0 +1,136 @@
+package money
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{in: "12.5", want: 1250},
+		{in: "-3.99", want: -399},
+		{in: " 7 ", want: 700},
+		{in: "0.05", want: 5},
+		{in: ".5", want: 50},
+		{in: "5.", want: 500},
+		{in: "--5", wantErr: true},
+		{in: "+5", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: ".", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "1.-5", wantErr: true},
+		{in: "1,50", wantErr: true},
+		{in: "12.345", wantErr: true},
+		{in: "92233720368547758.07", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{123456, "1234.56"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMulRate(t *testing.T) {
+	tests := []struct {
+		a    Amount
+		bp   int
+		want Amount
+	}{
+		{1000, 2000, 200},
+		{1000, 725, 73},
+		{-1000, 725, -73},
+		{999, 2000, 200},
+		{1, 4999, 0},
+		{1, 5000, 1},
+		{-1, 5000, -1},
+		{1000, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.a.MulRate(tt.bp); got != tt.want {
+			t.Errorf("%v.MulRate(%d) = %v, want %v", tt.a, tt.bp, got, tt.want)
+		}
+	}
+}
+
+func TestIncludedTax(t *testing.T) {
+	tests := []struct {
+		a    Amount
+		bp   int
+		want Amount
+	}{
+		{12000, 2000, 2000},
+		{100, 2000, 17},
+		{1070, 700, 70},
+		{-12000, 2000, -2000},
+		{5000, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.a.IncludedTax(tt.bp); got != tt.want {
+			t.Errorf("%v.IncludedTax(%d) = %v, want %v", tt.a, tt.bp, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		a       Amount
+		weights []Amount
+		want    []Amount
+	}{
+		{100, []Amount{1, 1, 1}, []Amount{34, 33, 33}},
+		{1000, []Amount{3000, 1000}, []Amount{750, 250}},
+		{-100, []Amount{1, 1, 1}, []Amount{-34, -33, -33}},
+		{100, []Amount{1, 2}, []Amount{33, 67}},
+		{10, []Amount{1, 1, 1, 4}, []Amount{2, 1, 1, 6}},
+		{100, []Amount{0, 1, 1, 1}, []Amount{0, 34, 33, 33}},
+		{100, []Amount{1, 0, 1}, []Amount{50, 0, 50}},
+		{101, []Amount{1, -5, 1}, []Amount{51, 0, 50}},
+		{100, []Amount{0, 0}, []Amount{0, 0}},
+		{100, []Amount{}, []Amount{}},
+	}
+	for _, tt := range tests {
+		got := tt.a.Split(tt.weights)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v.Split(%v) = %v, want %v", tt.a, tt.weights, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	var a Amount
+	if err := a.UnmarshalJSON([]byte(`"19.99"`)); err != nil || a != 1999 {
+		t.Errorf("UnmarshalJSON string = %v, %v, want 19.99", a, err)
+	}
+	if err := a.UnmarshalJSON([]byte(`4.5`)); err != nil || a != 450 {
+		t.Errorf("UnmarshalJSON number = %v, %v, want 4.50", a, err)
+	}
+}
@@ -0,0 +1,95 @@
+package orders
+
+import (
+	"3legant/types"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrIllegalTransition is returned for status changes the lifecycle does not
+// allow.
+var ErrIllegalTransition = errors.New("illegal order transition")
+
+var transitions = map[types.OrderStatus][]types.OrderStatus{
+	types.OrderPendingPayment: {types.OrderPaid, types.OrderCancelled},
+	types.OrderPaid:           {types.OrderProcessing, types.OrderCancelled, types.OrderRefunded},
+	types.OrderProcessing:     {types.OrderShipped, types.OrderCancelled, types.OrderRefunded},
+	types.OrderShipped:        {types.OrderDelivered},
+	types.OrderDelivered:      {types.OrderRefunded},
+}
+
+// Next returns the statuses an order in status can move to.
+func Next(status types.OrderStatus) []types.OrderStatus {
+	return transitions[status]
+}
+
+func CanTransition(from, to types.OrderStatus) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+type Store interface {
+	GetOrderByID(int) (*types.Order, error)
+	TransitionOrder(int, types.OrderStatus, types.OrderStatus, int, string) error
+	MarkReviewsVerified(int, []int) error
+}
+
+// Hook runs after an order entered a status. The transition has already been
+// stored, so a failing hook is only logged.
+type Hook func(order *types.Order) error
+
+// Machine moves orders through their lifecycle.
+type Machine struct {
+	Store Store
+	hooks map[types.OrderStatus][]Hook
+}
+
+func NewMachine(store Store) *Machine {
+	m := &Machine{Store: store, hooks: map[types.OrderStatus][]Hook{}}
+	m.OnEnter(types.OrderDelivered, m.verifyReviews)
+	return m
+}
+
+func (m *Machine) OnEnter(status types.OrderStatus, hook Hook) {
+	m.hooks[status] = append(m.hooks[status], hook)
+}
+
+// Transition moves the order to status on behalf of actorID and returns the
+// updated order.
+func (m *Machine) Transition(id int, to types.OrderStatus, actorID int, note string) (*types.Order, error) {
+	order, err := m.Store.GetOrderByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !CanTransition(order.Status, to) {
+		return nil, fmt.Errorf("%w: cannot move order %d from %s to %s", ErrIllegalTransition, id, order.Status, to)
+	}
+	if err := m.Store.TransitionOrder(id, order.Status, to, actorID, note); err != nil {
+		return nil, err
+	}
+	order, err = m.Store.GetOrderByID(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range m.hooks[to] {
+		if err := hook(order); err != nil {
+			log.Printf("order %d %s hook: %v", id, to, err)
+		}
+	}
+	return order, nil
+}
+
+// verifyReviews marks the customer's reviews of delivered products as
+// verified purchases.
+func (m *Machine) verifyReviews(order *types.Order) error {
+	prodIDs := make([]int, 0, len(order.Lines))
+	for _, line := range order.Lines {
+		prodIDs = append(prodIDs, line.ProdID)
+	}
+	return m.Store.MarkReviewsVerified(order.AccID, prodIDs)
+}
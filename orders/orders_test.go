@@ -0,0 +1,103 @@
+package orders
+
+import (
+	"3legant/types"
+	"errors"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to types.OrderStatus
+		want     bool
+	}{
+		{types.OrderPendingPayment, types.OrderPaid, true},
+		{types.OrderPendingPayment, types.OrderCancelled, true},
+		{types.OrderPendingPayment, types.OrderShipped, false},
+		{types.OrderPendingPayment, types.OrderRefunded, false},
+		{types.OrderPaid, types.OrderProcessing, true},
+		{types.OrderPaid, types.OrderCancelled, true},
+		{types.OrderPaid, types.OrderRefunded, true},
+		{types.OrderPaid, types.OrderPendingPayment, false},
+		{types.OrderProcessing, types.OrderShipped, true},
+		{types.OrderProcessing, types.OrderCancelled, true},
+		{types.OrderShipped, types.OrderDelivered, true},
+		{types.OrderShipped, types.OrderCancelled, false},
+		{types.OrderShipped, types.OrderRefunded, false},
+		{types.OrderDelivered, types.OrderRefunded, true},
+		{types.OrderDelivered, types.OrderShipped, false},
+		{types.OrderCancelled, types.OrderPaid, false},
+		{types.OrderRefunded, types.OrderPaid, false},
+		{types.OrderPaid, types.OrderPaid, false},
+		{"unknown", types.OrderPaid, false},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestFinalStatuses(t *testing.T) {
+	for _, status := range []types.OrderStatus{types.OrderCancelled, types.OrderRefunded} {
+		if next := Next(status); len(next) != 0 {
+			t.Errorf("Next(%s) = %v, want none", status, next)
+		}
+	}
+}
+
+type fakeStore struct {
+	order    *types.Order
+	verified []int
+}
+
+func (s *fakeStore) GetOrderByID(id int) (*types.Order, error) {
+	copied := *s.order
+	return &copied, nil
+}
+
+func (s *fakeStore) TransitionOrder(id int, from, to types.OrderStatus, actorID int, note string) error {
+	s.order.Status = to
+	return nil
+}
+
+func (s *fakeStore) MarkReviewsVerified(accID int, prodIDs []int) error {
+	s.verified = prodIDs
+	return nil
+}
+
+func TestTransition(t *testing.T) {
+	store := &fakeStore{order: &types.Order{
+		ID:     1,
+		AccID:  2,
+		Status: types.OrderShipped,
+		Lines:  []*types.OrderLine{{ProdID: 3}, {ProdID: 4}},
+	}}
+	m := NewMachine(store)
+	var entered []types.OrderStatus
+	m.OnEnter(types.OrderDelivered, func(order *types.Order) error {
+		entered = append(entered, order.Status)
+		return errors.New("hook errors are only logged")
+	})
+
+	if _, err := m.Transition(1, types.OrderCancelled, 5, ""); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("shipped to cancelled: err = %v, want ErrIllegalTransition", err)
+	}
+	if len(entered) != 0 {
+		t.Fatalf("hook ran for an illegal transition")
+	}
+
+	order, err := m.Transition(1, types.OrderDelivered, 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != types.OrderDelivered {
+		t.Errorf("status = %s, want delivered", order.Status)
+	}
+	if len(entered) != 1 || entered[0] != types.OrderDelivered {
+		t.Errorf("hook saw %v, want [delivered]", entered)
+	}
+	if len(store.verified) != 2 || store.verified[0] != 3 || store.verified[1] != 4 {
+		t.Errorf("verified reviews of %v, want [3 4]", store.verified)
+	}
+}
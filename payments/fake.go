@@ -0,0 +1,185 @@
+package payments
+
+import (
+	"3legant/money"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Test tokens understood by FakeGateway.
+const (
+	FakeTokenApprove    = "fake_approve"
+	FakeTokenDecline    = "fake_decline"
+	FakeToken3DSecure   = "fake_3ds"
+	FakeSignatureHeader = "X-Fake-Signature"
+)
+
+// FakeGateway is an in-process Provider for local development and tests.
+// Intents are kept in memory and lost on restart.
+// Its behaviour depends only on the token: FakeTokenDecline is declined,
+// FakeToken3DSecure needs a challenge that is completed through
+// CompleteChallenge, everything else is approved. Webhooks are posted to
+// WebhookURL signed with Secret.
+type FakeGateway struct {
+	Secret     string
+	WebhookURL string
+	// ChallengeURL is prefixed to the intent ID to build NextAction.
+	ChallengeURL string
+
+	mu      sync.Mutex
+	seq     int
+	intents map[string]*fakeIntent
+	client  *http.Client
+}
+
+type fakeIntent struct {
+	Intent
+	refunded money.Amount
+}
+
+func NewFakeGateway(secret, webhookURL, challengeURL string) *FakeGateway {
+	return &FakeGateway{
+		Secret:       secret,
+		WebhookURL:   webhookURL,
+		ChallengeURL: challengeURL,
+		intents:      map[string]*fakeIntent{},
+		client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (g *FakeGateway) Authorize(req *AuthorizeRequest) (*Intent, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.seq++
+	intent := &fakeIntent{Intent: Intent{ID: fmt.Sprintf("pi_fake_%06d", g.seq), Amount: req.Amount}}
+	switch req.Token {
+	case FakeTokenDecline:
+		intent.Status = IntentFailed
+		intent.DeclineReason = "card_declined"
+	case FakeToken3DSecure:
+		intent.Status = IntentRequiresAction
+		intent.NextAction = g.ChallengeURL + intent.ID
+	default:
+		intent.Status = IntentAuthorized
+	}
+	g.intents[intent.ID] = intent
+	out := intent.Intent
+	return &out, nil
+}
+
+// CompleteChallenge finishes the 3-D Secure challenge of an intent and
+// notifies the webhook of the outcome.
+func (g *FakeGateway) CompleteChallenge(intentID string, pass bool) error {
+	g.mu.Lock()
+	intent, err := g.get(intentID, IntentRequiresAction)
+	event := &Event{IntentID: intentID}
+	if err == nil {
+		intent.NextAction = ""
+		if pass {
+			intent.Status = IntentAuthorized
+			event.Type = EventAuthorized
+		} else {
+			intent.Status = IntentFailed
+			intent.DeclineReason = "authentication_failed"
+			event.Type = EventFailed
+			event.Reason = intent.DeclineReason
+		}
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return g.notify(event)
+}
+
+func (g *FakeGateway) notify(event *Event) error {
+	if g.WebhookURL == "" {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", g.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(FakeSignatureHeader, Sign(g.Secret, payload))
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (g *FakeGateway) Capture(intentID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	intent, err := g.get(intentID, IntentAuthorized)
+	if err != nil {
+		return err
+	}
+	intent.Status = IntentCaptured
+	return nil
+}
+
+func (g *FakeGateway) Void(intentID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	intent, err := g.get(intentID, IntentAuthorized)
+	if err != nil {
+		return err
+	}
+	intent.Status = IntentVoided
+	return nil
+}
+
+func (g *FakeGateway) Refund(intentID string, amount money.Amount) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	intent, err := g.get(intentID, IntentCaptured)
+	if err != nil {
+		return err
+	}
+	if amount <= 0 || intent.refunded+amount > intent.Amount {
+		return fmt.Errorf("cannot refund %s of %s, %s already refunded", amount, intent.Amount, intent.refunded)
+	}
+	intent.refunded += amount
+	return nil
+}
+
+func (g *FakeGateway) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	if !VerifySignature(g.Secret, payload, header.Get(FakeSignatureHeader)) {
+		return nil, ErrInvalidSignature
+	}
+	event := new(Event)
+	if err := json.Unmarshal(payload, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// get returns the intent if it is in status. g.mu must be held.
+func (g *FakeGateway) get(intentID string, status IntentStatus) (*fakeIntent, error) {
+	intent, ok := g.intents[intentID]
+	if !ok {
+		return nil, fmt.Errorf("payment intent %s not found", intentID)
+	}
+	if intent.Status != status {
+		return nil, fmt.Errorf("payment intent %s is %s, not %s", intentID, intent.Status, status)
+	}
+	return intent, nil
+}
@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"3legant/money"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+type IntentStatus string
+
+const (
+	IntentRequiresAction IntentStatus = "requires_action"
+	IntentAuthorized     IntentStatus = "authorized"
+	IntentCaptured       IntentStatus = "captured"
+	IntentVoided         IntentStatus = "voided"
+	IntentFailed         IntentStatus = "failed"
+)
+
+// Intent is a payment attempt at the provider.
+type Intent struct {
+	ID     string       `json:"id"`
+	Status IntentStatus `json:"status"`
+	Amount money.Amount `json:"amount"`
+	// NextAction is where the customer completes a 3-D Secure challenge
+	// when Status is requires_action.
+	NextAction    string `json:"nextAction,omitempty"`
+	DeclineReason string `json:"declineReason,omitempty"`
+}
+
+type AuthorizeRequest struct {
+	OrderID int
+	Amount  money.Amount
+	// Token identifies the payment method, as handed out by the provider's
+	// client side library.
+	Token string
+}
+
+type EventType string
+
+const (
+	EventAuthorized EventType = "payment_intent.authorized"
+	EventFailed     EventType = "payment_intent.failed"
+)
+
+// Event is a webhook notification from the provider.
+type Event struct {
+	Type     EventType `json:"type"`
+	IntentID string    `json:"intentID"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Provider is a payment gateway. Authorize may finish asynchronously, in
+// which case the outcome arrives as a webhook Event.
+type Provider interface {
+	Authorize(*AuthorizeRequest) (*Intent, error)
+	Capture(intentID string) error
+	Void(intentID string) error
+	Refund(intentID string, amount money.Amount) error
+	// VerifyWebhook checks the signature of a webhook request and decodes
+	// its event.
+	VerifyWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifySignature(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, payload)))
+}
@@ -0,0 +1,165 @@
+package pricing
+
+import (
+	"3legant/money"
+	"3legant/types"
+	"fmt"
+)
+
+// Discount is a price reduction produced by a Discounter. Amount is spread
+// over the cart lines in proportion to their totals unless Lines assigns it
+// to specific products.
+type Discount struct {
+	PromotionID  int
+	Code         string
+	Description  string
+	Amount       money.Amount
+	Lines        map[int]money.Amount
+	FreeShipping bool
+}
+
+type Discounter interface {
+	Discounts(*types.CartSummary) ([]*Discount, error)
+}
+
+// TaxCalculator fills in the tax of the discounted cart lines, the tax
+// breakdown and the cart's total tax.
+type TaxCalculator interface {
+	CalculateTax(*types.CartSummary) error
+}
+
+type ShippingEstimator interface {
+	EstimateShipping(*types.CartSummary) (money.Amount, error)
+}
+
+// Engine turns cart contents into a priced CartSummary.
+type Engine struct {
+	Discounters []Discounter
+	Tax         TaxCalculator
+	Shipping    ShippingEstimator
+}
+
+func (e *Engine) Price(cart *types.Cart, items []*types.ProductQuantity) (*types.CartSummary, error) {
+	summary := &types.CartSummary{
+		CartID:         cart.CartID,
+		UserID:         cart.UserID,
+		Destination:    cart.Destination,
+		ShippingMethod: cart.ShippingMethod,
+		Lines:          []*types.CartLine{},
+		Discounts:      []*types.CartAdjustment{},
+		Taxes:          []*types.TaxLine{},
+	}
+	for _, item := range items {
+		unit := money.FromFloat(item.Product.Price)
+		line := &types.CartLine{
+			Product:   item.Product,
+			Quantity:  item.Quantity,
+			UnitPrice: unit,
+			LineTotal: unit.Mul(item.Quantity),
+			Warnings:  lineWarnings(item, unit),
+		}
+		summary.Lines = append(summary.Lines, line)
+		summary.Subtotal += line.LineTotal
+	}
+
+	freeShipping := false
+	for _, discounter := range e.Discounters {
+		discounts, err := discounter.Discounts(summary)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range discounts {
+			applied := applyDiscount(summary, d)
+			if applied > 0 || d.FreeShipping {
+				summary.Discounts = append(summary.Discounts, &types.CartAdjustment{
+					Code:        d.Code,
+					Description: d.Description,
+					Amount:      applied,
+					PromotionID: d.PromotionID,
+				})
+			}
+			freeShipping = freeShipping || d.FreeShipping
+		}
+	}
+
+	if e.Tax != nil {
+		if err := e.Tax.CalculateTax(summary); err != nil {
+			return nil, err
+		}
+	}
+	if e.Shipping != nil && len(summary.Lines) > 0 && !freeShipping {
+		shipping, err := e.Shipping.EstimateShipping(summary)
+		if err != nil {
+			return nil, err
+		}
+		summary.Shipping = shipping
+	}
+	summary.Total = summary.Subtotal - summary.Discount + summary.Shipping
+	if !summary.TaxIncluded {
+		summary.Total += summary.Tax
+	}
+	return summary, nil
+}
+
+// lineWarnings reports what changed about a cart item since it was added.
+func lineWarnings(item *types.ProductQuantity, unit money.Amount) []*types.CartWarning {
+	var warnings []*types.CartWarning
+	product := item.Product
+	if product.Archived {
+		warnings = append(warnings, &types.CartWarning{
+			Code:    types.CartWarningArchived,
+			Message: fmt.Sprintf("%s is no longer available", product.Name),
+		})
+	} else if product.Stock != nil && *product.Stock <= 0 {
+		warnings = append(warnings, &types.CartWarning{
+			Code:    types.CartWarningOutOfStock,
+			Message: fmt.Sprintf("%s is out of stock", product.Name),
+		})
+	} else if product.Stock != nil && *product.Stock < item.Quantity {
+		warnings = append(warnings, &types.CartWarning{
+			Code:    types.CartWarningInsufficientStock,
+			Message: fmt.Sprintf("only %d of %s in stock", *product.Stock, product.Name),
+		})
+	}
+	if item.AddedPrice != nil && *item.AddedPrice != unit {
+		old := *item.AddedPrice
+		warnings = append(warnings, &types.CartWarning{
+			Code:     types.CartWarningPriceChanged,
+			Message:  fmt.Sprintf("the price of %s changed from %s to %s", product.Name, old, unit),
+			OldPrice: &old,
+			NewPrice: &unit,
+		})
+	}
+	return warnings
+}
+
+// applyDiscount subtracts d from the cart lines and returns how much was
+// actually taken off. A line never drops below zero.
+func applyDiscount(summary *types.CartSummary, d *Discount) money.Amount {
+	alloc := d.Lines
+	if alloc == nil {
+		weights := make([]money.Amount, len(summary.Lines))
+		for i, line := range summary.Lines {
+			weights[i] = line.LineTotal
+		}
+		parts := d.Amount.Split(weights)
+		alloc = map[int]money.Amount{}
+		for i, line := range summary.Lines {
+			alloc[line.Product.ID] += parts[i]
+		}
+	}
+	var applied money.Amount
+	for _, line := range summary.Lines {
+		amount := money.Min(alloc[line.Product.ID], line.LineTotal)
+		if amount <= 0 {
+			continue
+		}
+		// spend the allocation only once if a product appears on several lines
+		alloc[line.Product.ID] -= amount
+		line.Discount += amount
+		line.LineTotal -= amount
+		applied += amount
+	}
+	summary.Discount += applied
+	return applied
+}
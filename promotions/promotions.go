@@ -0,0 +1,219 @@
+package promotions
+
+import (
+	"3legant/money"
+	"3legant/pricing"
+	"3legant/types"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Store interface {
+	GetAutomaticPromotions() ([]*types.Promotion, error)
+	GetCartCoupon(int) (*types.Promotion, error)
+	GetProductCategories([]int) (map[int][]string, error)
+	CountRedemptions(int, int) (int, error)
+}
+
+// Discounter applies the automatic promotions and the cart's coupon. It
+// implements pricing.Discounter.
+type Discounter struct {
+	Store Store
+	Now   func() time.Time
+}
+
+func (d *Discounter) Discounts(summary *types.CartSummary) ([]*pricing.Discount, error) {
+	promos, err := d.Store.GetAutomaticPromotions()
+	if err != nil {
+		return nil, err
+	}
+	coupon, err := d.Store.GetCartCoupon(summary.CartID)
+	if err != nil {
+		return nil, err
+	}
+	if coupon != nil {
+		promos = append(promos, coupon)
+	}
+	if len(promos) == 0 {
+		return nil, nil
+	}
+	categories, err := d.categories(summary)
+	if err != nil {
+		return nil, err
+	}
+
+	discounts := []*pricing.Discount{}
+	for _, promo := range promos {
+		if err := d.Eligible(promo, summary, categories); err != nil {
+			// an ineligible coupon stays on the cart but does nothing until
+			// the cart qualifies again
+			continue
+		}
+		if discount := Discount(promo, summary, categories); discount != nil {
+			discounts = append(discounts, discount)
+		}
+	}
+	return discounts, nil
+}
+
+func (d *Discounter) categories(summary *types.CartSummary) (map[int][]string, error) {
+	ids := make([]int, 0, len(summary.Lines))
+	for _, line := range summary.Lines {
+		ids = append(ids, line.Product.ID)
+	}
+	return d.Store.GetProductCategories(ids)
+}
+
+func (d *Discounter) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+// Check reports why the promotion cannot be used on the cart, or nil if it
+// can.
+func (d *Discounter) Check(promo *types.Promotion, summary *types.CartSummary) error {
+	categories, err := d.categories(summary)
+	if err != nil {
+		return err
+	}
+	return d.Eligible(promo, summary, categories)
+}
+
+// Eligible reports why the promotion does not apply to the cart, or nil if
+// it does.
+func (d *Discounter) Eligible(promo *types.Promotion, summary *types.CartSummary, categories map[int][]string) error {
+	now := d.now()
+	if !promo.Active {
+		return fmt.Errorf("promotion is not active")
+	}
+	if promo.StartsAt != nil && now.Before(*promo.StartsAt) {
+		return fmt.Errorf("promotion has not started yet")
+	}
+	if promo.EndsAt != nil && !now.Before(*promo.EndsAt) {
+		return fmt.Errorf("promotion has expired")
+	}
+	if promo.UsageLimit > 0 && promo.TimesUsed >= promo.UsageLimit {
+		return fmt.Errorf("promotion is no longer available")
+	}
+	if promo.PerCustomerLimit > 0 && summary.UserID != 0 {
+		used, err := d.Store.CountRedemptions(promo.ID, summary.UserID)
+		if err != nil {
+			return err
+		}
+		if used >= promo.PerCustomerLimit {
+			return fmt.Errorf("promotion already used")
+		}
+	}
+	if eligibleSubtotal(promo, summary, categories) < promo.MinSubtotal {
+		return fmt.Errorf("cart subtotal must be at least %s", promo.MinSubtotal)
+	}
+	if promo.Category != "" && eligibleSubtotal(promo, summary, categories) == 0 {
+		return fmt.Errorf("promotion only applies to %s products", promo.Category)
+	}
+	return nil
+}
+
+// Discount computes what the promotion takes off the cart. It does not check
+// eligibility.
+func Discount(promo *types.Promotion, summary *types.CartSummary, categories map[int][]string) *pricing.Discount {
+	discount := &pricing.Discount{PromotionID: promo.ID, Code: promo.Code, Description: promo.Name}
+	lines := map[int]money.Amount{}
+	switch promo.Kind {
+	case types.PromotionPercentOff:
+		for _, line := range summary.Lines {
+			if inCategory(promo, line, categories) {
+				lines[line.Product.ID] += line.LineTotal.MulRate(promo.Percent * 100)
+			}
+		}
+	case types.PromotionFixedOff:
+		eligible := []*types.CartLine{}
+		weights := []money.Amount{}
+		for _, line := range summary.Lines {
+			if inCategory(promo, line, categories) {
+				eligible = append(eligible, line)
+				weights = append(weights, line.LineTotal)
+			}
+		}
+		for i, part := range promo.AmountOff.Split(weights) {
+			lines[eligible[i].Product.ID] += part
+		}
+	case types.PromotionBuyXGetY:
+		group := promo.BuyQuantity + promo.GetQuantity
+		for _, line := range summary.Lines {
+			if group > 0 && inCategory(promo, line, categories) {
+				free := line.Quantity / group * promo.GetQuantity
+				lines[line.Product.ID] += line.UnitPrice.Mul(free)
+			}
+		}
+	case types.PromotionFreeShipping:
+		discount.FreeShipping = true
+	default:
+		return nil
+	}
+	for _, amount := range lines {
+		discount.Amount += amount
+	}
+	discount.Lines = lines
+	return discount
+}
+
+func inCategory(promo *types.Promotion, line *types.CartLine, categories map[int][]string) bool {
+	if promo.Category == "" {
+		return true
+	}
+	for _, c := range categories[line.Product.ID] {
+		if strings.EqualFold(c, promo.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+func eligibleSubtotal(promo *types.Promotion, summary *types.CartSummary, categories map[int][]string) money.Amount {
+	var total money.Amount
+	for _, line := range summary.Lines {
+		if inCategory(promo, line, categories) {
+			total += line.LineTotal
+		}
+	}
+	return total
+}
+
+// Validate checks that a promotion created by an admin is well formed.
+func Validate(promo *types.Promotion) error {
+	if len(promo.Name) == 0 {
+		return fmt.Errorf("empty name")
+	}
+	switch promo.Kind {
+	case types.PromotionPercentOff:
+		if promo.Percent < 1 || promo.Percent > 100 {
+			return fmt.Errorf("percent must be between 1 and 100")
+		}
+	case types.PromotionFixedOff:
+		if promo.AmountOff <= 0 {
+			return fmt.Errorf("amountOff must be positive")
+		}
+	case types.PromotionBuyXGetY:
+		if promo.BuyQuantity < 1 || promo.GetQuantity < 1 {
+			return fmt.Errorf("buyQuantity and getQuantity must be positive")
+		}
+	case types.PromotionFreeShipping:
+	default:
+		return fmt.Errorf("invalid kind %s", promo.Kind)
+	}
+	if promo.MinSubtotal < 0 || promo.UsageLimit < 0 || promo.PerCustomerLimit < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if promo.StartsAt != nil && promo.EndsAt != nil && !promo.EndsAt.After(*promo.StartsAt) {
+		return fmt.Errorf("endsAt must be after startsAt")
+	}
+	return nil
+}
+
+// NormalizeCode makes coupon codes case insensitive.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
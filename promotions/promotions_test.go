@@ -0,0 +1,96 @@
+package promotions
+
+import (
+	"3legant/money"
+	"3legant/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiscount(t *testing.T) {
+	summary := &types.CartSummary{Lines: []*types.CartLine{
+		{Product: &types.Product{ID: 1}, Quantity: 2, UnitPrice: 5000, LineTotal: 10000},
+		{Product: &types.Product{ID: 2}, Quantity: 3, UnitPrice: 1000, LineTotal: 3000},
+	}}
+	categories := map[int][]string{1: {"Living"}, 2: {"Lighting"}}
+	tests := []struct {
+		name      string
+		promo     *types.Promotion
+		wantLines map[int]money.Amount
+		wantTotal money.Amount
+	}{
+		{
+			name:      "percent off",
+			promo:     &types.Promotion{Kind: types.PromotionPercentOff, Percent: 10},
+			wantLines: map[int]money.Amount{1: 1000, 2: 300},
+			wantTotal: 1300,
+		},
+		{
+			name:      "percent off a category",
+			promo:     &types.Promotion{Kind: types.PromotionPercentOff, Percent: 10, Category: "living"},
+			wantLines: map[int]money.Amount{1: 1000},
+			wantTotal: 1000,
+		},
+		{
+			name:      "fixed off spread by line total",
+			promo:     &types.Promotion{Kind: types.PromotionFixedOff, AmountOff: 1000},
+			wantLines: map[int]money.Amount{1: 769, 2: 231},
+			wantTotal: 1000,
+		},
+		{
+			name:      "buy two get one",
+			promo:     &types.Promotion{Kind: types.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1},
+			wantLines: map[int]money.Amount{1: 0, 2: 1000},
+			wantTotal: 1000,
+		},
+		{
+			name:      "free shipping",
+			promo:     &types.Promotion{Kind: types.PromotionFreeShipping},
+			wantLines: map[int]money.Amount{},
+			wantTotal: 0,
+		},
+	}
+	for _, tt := range tests {
+		got := Discount(tt.promo, summary, categories)
+		if got == nil {
+			t.Errorf("%s: no discount", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(got.Lines, tt.wantLines) || got.Amount != tt.wantTotal {
+			t.Errorf("%s: discount = %v %v, want %v %v", tt.name, got.Amount, got.Lines, tt.wantTotal, tt.wantLines)
+		}
+		if got.FreeShipping != (tt.promo.Kind == types.PromotionFreeShipping) {
+			t.Errorf("%s: FreeShipping = %v", tt.name, got.FreeShipping)
+		}
+	}
+
+	if got := Discount(&types.Promotion{Kind: "unknown"}, summary, categories); got != nil {
+		t.Errorf("unknown kind: discount = %+v, want nil", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(-time.Hour)
+	tests := []struct {
+		name    string
+		promo   types.Promotion
+		wantErr bool
+	}{
+		{"percent", types.Promotion{Name: "Sale", Kind: types.PromotionPercentOff, Percent: 15}, false},
+		{"no name", types.Promotion{Kind: types.PromotionPercentOff, Percent: 15}, true},
+		{"percent over 100", types.Promotion{Name: "Sale", Kind: types.PromotionPercentOff, Percent: 101}, true},
+		{"fixed without amount", types.Promotion{Name: "Sale", Kind: types.PromotionFixedOff}, true},
+		{"buy without get", types.Promotion{Name: "Sale", Kind: types.PromotionBuyXGetY, BuyQuantity: 2}, true},
+		{"free shipping", types.Promotion{Name: "Sale", Kind: types.PromotionFreeShipping}, false},
+		{"unknown kind", types.Promotion{Name: "Sale", Kind: "unknown"}, true},
+		{"negative limit", types.Promotion{Name: "Sale", Kind: types.PromotionFreeShipping, UsageLimit: -1}, true},
+		{"ends before start", types.Promotion{Name: "Sale", Kind: types.PromotionFreeShipping, StartsAt: &start, EndsAt: &end}, true},
+	}
+	for _, tt := range tests {
+		if err := Validate(&tt.promo); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package shipping
+
+import (
+	"3legant/money"
+	"3legant/types"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	MethodStandard   = "standard"
+	MethodExpress    = "express"
+	MethodWhiteGlove = "white_glove"
+)
+
+var methodNames = map[string]string{
+	MethodStandard:   "Standard delivery",
+	MethodExpress:    "Express delivery",
+	MethodWhiteGlove: "White glove delivery and assembly",
+}
+
+// Rate prices one shipping method in a zone.
+type Rate struct {
+	Base  money.Amount
+	PerKg money.Amount
+	// FreeOver waives the rate once the discounted subtotal reaches it. Zero
+	// means never.
+	FreeOver money.Amount
+	MinDays  int
+	MaxDays  int
+}
+
+// Zone is a set of destinations that share rates. A zone without Countries
+// matches every destination. PostalPrefixes narrow a zone down to parts of
+// its countries.
+type Zone struct {
+	Code           string
+	Countries      []string
+	PostalPrefixes []string
+	Rates          map[string]*Rate
+}
+
+func (z *Zone) matches(dest types.Destination) bool {
+	if len(z.Countries) == 0 {
+		return true
+	}
+	if !contains(z.Countries, dest.Country) {
+		return false
+	}
+	if len(z.PostalPrefixes) == 0 {
+		return true
+	}
+	postal := strings.ReplaceAll(strings.ToUpper(dest.PostalCode), " ", "")
+	for _, prefix := range z.PostalPrefixes {
+		if strings.HasPrefix(postal, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Calculator quotes shipping for carts. It implements
+// pricing.ShippingEstimator.
+type Calculator struct {
+	// Zones are tried in order, the first match wins.
+	Zones []*Zone
+	// Home is assumed for carts without a destination.
+	Home types.Destination
+}
+
+func (c *Calculator) zone(dest types.Destination) (*Zone, error) {
+	if dest.Country == "" {
+		dest = c.Home
+	}
+	dest.Country = strings.ToUpper(dest.Country)
+	for _, zone := range c.Zones {
+		if zone.matches(dest) {
+			return zone, nil
+		}
+	}
+	return nil, fmt.Errorf("no shipping to %s", dest.Country)
+}
+
+// Quote lists the shipping methods available for the cart at dest, cheapest
+// first.
+func (c *Calculator) Quote(summary *types.CartSummary, dest types.Destination) ([]*types.ShippingQuote, error) {
+	zone, err := c.zone(dest)
+	if err != nil {
+		return nil, err
+	}
+	quotes := []*types.ShippingQuote{}
+	for _, method := range []string{MethodStandard, MethodExpress, MethodWhiteGlove} {
+		rate, ok := zone.Rates[method]
+		if !ok {
+			continue
+		}
+		quotes = append(quotes, &types.ShippingQuote{
+			Method:  method,
+			Name:    methodNames[method],
+			Zone:    zone.Code,
+			Amount:  rate.price(summary),
+			MinDays: rate.MinDays,
+			MaxDays: rate.MaxDays,
+		})
+	}
+	return quotes, nil
+}
+
+// Check returns an error unless method ships to dest.
+func (c *Calculator) Check(dest types.Destination, method string) error {
+	zone, err := c.zone(dest)
+	if err != nil {
+		return err
+	}
+	if _, ok := zone.Rates[method]; !ok {
+		return fmt.Errorf("%s is not available for %s", method, strings.ToUpper(dest.Country))
+	}
+	return nil
+}
+
+// EstimateShipping prices the cart's chosen method, standard delivery if it
+// has none or the method is not available at its destination.
+func (c *Calculator) EstimateShipping(summary *types.CartSummary) (money.Amount, error) {
+	zone, err := c.zone(summary.Destination)
+	if err != nil {
+		return 0, err
+	}
+	rate, ok := zone.Rates[summary.ShippingMethod]
+	if !ok {
+		rate, ok = zone.Rates[MethodStandard]
+	}
+	if !ok {
+		return 0, fmt.Errorf("no standard delivery in zone %s", zone.Code)
+	}
+	return rate.price(summary), nil
+}
+
+func (r *Rate) price(summary *types.CartSummary) money.Amount {
+	if r.FreeOver > 0 && summary.Subtotal-summary.Discount >= r.FreeOver {
+		return 0
+	}
+	kg := 0.0
+	for _, line := range summary.Lines {
+		kg += BillableWeight(line.Product) * float64(line.Quantity)
+	}
+	return r.Base + r.PerKg.Mul(int(math.Ceil(kg)))
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, str) {
+			return true
+		}
+	}
+	return false
+}
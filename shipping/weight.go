@@ -0,0 +1,72 @@
+package shipping
+
+import (
+	"3legant/types"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// volumetricDivisor converts cm³ to kg the way parcel carriers do.
+	volumetricDivisor = 5000
+	// defaultWeight is assumed for products without weight or dimensions.
+	defaultWeight = 2.0
+)
+
+// BillableWeight is the weight a product is charged for: its actual weight or
+// its volumetric weight, whichever is higher.
+func BillableWeight(product *types.Product) float64 {
+	weight := 0.0
+	if product.Weight != nil {
+		weight = *product.Weight
+	}
+	if dims, ok := ParseDimensions(product.Measurements); ok {
+		volumetric := dims[0] * dims[1] * dims[2] / volumetricDivisor
+		if volumetric > weight {
+			weight = volumetric
+		}
+	}
+	if weight <= 0 {
+		return defaultWeight
+	}
+	return weight
+}
+
+var (
+	dimensionPattern = regexp.MustCompile(`(\d+(?:[.,]\d+)?)(?:\s+(\d+)/(\d+))?`)
+	inchPattern      = regexp.MustCompile(`(?i)"|\d\s*in\b|inch`)
+)
+
+// ParseDimensions reads the first three numbers of a measurements string
+// such as `17 1/2x20 5/8x30"` or "120 x 60 x 75 cm" and returns them in cm.
+// Inches are assumed for " and in, cm otherwise.
+func ParseDimensions(measurements string) ([3]float64, bool) {
+	var dims [3]float64
+	matches := dimensionPattern.FindAllStringSubmatch(measurements, 3)
+	if len(matches) < 3 {
+		return dims, false
+	}
+	scale := 1.0
+	switch {
+	case inchPattern.MatchString(measurements):
+		scale = 2.54
+	case strings.Contains(strings.ToLower(measurements), "mm"):
+		scale = 0.1
+	}
+	for i, m := range matches {
+		n, err := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+		if err != nil {
+			return dims, false
+		}
+		if m[2] != "" {
+			num, _ := strconv.ParseFloat(m[2], 64)
+			den, _ := strconv.ParseFloat(m[3], 64)
+			if den != 0 {
+				n += num / den
+			}
+		}
+		dims[i] = n * scale
+	}
+	return dims, true
+}
@@ -0,0 +1,56 @@
+package shipping
+
+import (
+	"3legant/types"
+	"math"
+	"testing"
+)
+
+func TestParseDimensions(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   [3]float64
+		wantOK bool
+	}{
+		{"120 x 60 x 75 cm", [3]float64{120, 60, 75}, true},
+		{"120x60x75", [3]float64{120, 60, 75}, true},
+		{`17 1/2x20 5/8x30"`, [3]float64{44.45, 52.3875, 76.2}, true},
+		{"10 x 20 x 30 in", [3]float64{25.4, 50.8, 76.2}, true},
+		{"500 x 400 x 300 mm", [3]float64{50, 40, 30}, true},
+		{"24,5 x 10 x 3.5", [3]float64{24.5, 10, 3.5}, true},
+		{"60 x 40 cm", [3]float64{}, false},
+		{"", [3]float64{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseDimensions(tt.in)
+		if ok != tt.wantOK {
+			t.Errorf("ParseDimensions(%q) ok = %v, want %v", tt.in, ok, tt.wantOK)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+				t.Errorf("ParseDimensions(%q) = %v, want %v", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBillableWeight(t *testing.T) {
+	weight := func(kg float64) *float64 { return &kg }
+	tests := []struct {
+		name    string
+		product *types.Product
+		want    float64
+	}{
+		{"unknown", &types.Product{}, defaultWeight},
+		{"actual", &types.Product{Weight: weight(3)}, 3},
+		{"volumetric", &types.Product{Weight: weight(1), Measurements: "50 x 40 x 30 cm"}, 12},
+		{"heavier than volume", &types.Product{Weight: weight(20), Measurements: "50 x 40 x 30 cm"}, 20},
+	}
+	for _, tt := range tests {
+		if got := BillableWeight(tt.product); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: BillableWeight = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
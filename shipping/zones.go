@@ -0,0 +1,48 @@
+package shipping
+
+import (
+	"3legant/money"
+	"3legant/types"
+)
+
+// DefaultZones ships everywhere from home. Domestic standard delivery costs
+// fee and is free from freeOver; remote postal areas of the home country
+// and other countries cost more, and white glove delivery is domestic only.
+func DefaultZones(home types.Destination, fee, freeOver money.Amount) []*Zone {
+	zones := []*Zone{}
+	if prefixes := remotePostalPrefixes[home.Country]; len(prefixes) > 0 {
+		zones = append(zones, &Zone{
+			Code:           "remote",
+			Countries:      []string{home.Country},
+			PostalPrefixes: prefixes,
+			Rates: map[string]*Rate{
+				MethodStandard: {Base: fee * 2, PerKg: 150, MinDays: 7, MaxDays: 14},
+				MethodExpress:  {Base: fee * 4, PerKg: 300, MinDays: 3, MaxDays: 5},
+			},
+		})
+	}
+	return append(zones,
+		&Zone{
+			Code:      "domestic",
+			Countries: []string{home.Country},
+			Rates: map[string]*Rate{
+				MethodStandard:   {Base: fee, PerKg: 50, FreeOver: freeOver, MinDays: 3, MaxDays: 7},
+				MethodExpress:    {Base: fee * 2, PerKg: 100, MinDays: 1, MaxDays: 2},
+				MethodWhiteGlove: {Base: 14900, PerKg: 100, MinDays: 5, MaxDays: 10},
+			},
+		},
+		&Zone{
+			Code: "international",
+			Rates: map[string]*Rate{
+				MethodStandard: {Base: fee * 3, PerKg: 250, MinDays: 10, MaxDays: 21},
+				MethodExpress:  {Base: fee * 6, PerKg: 500, MinDays: 3, MaxDays: 6},
+			},
+		},
+	)
+}
+
+// remotePostalPrefixes are postal areas that are expensive to reach.
+var remotePostalPrefixes = map[string][]string{
+	// Hawaii and Alaska
+	"US": {"967", "968", "995", "996", "997", "998", "999"},
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"3legant/money"
+	"3legant/types"
+	"time"
+)
+
+// MigrateCartActivity tracks when a cart was last changed and when its owner
+// was last reminded of it.
+func (s *PostgresStore) MigrateCartActivity() error {
+	query := `alter table cart
+			add column if not exists updated_at timestamp not null default now(),
+			add column if not exists reminded_at timestamp`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func touchCart(db execer, cartID int) error {
+	_, err := db.Exec(`update cart set updated_at = now() where id = $1`, cartID)
+	return err
+}
+
+// GetAbandonedCarts returns the account carts with items that were not
+// changed for idle and whose owner has not been reminded since.
+func (s *PostgresStore) GetAbandonedCarts(idle time.Duration) ([]*types.AbandonedCart, error) {
+	query := `select c.id, a.id, coalesce(a.e_mail, ''), coalesce(a.first_name, ''), c.updated_at, sum(cp.quantity)
+			from cart c
+			join account a on a.id = c.user_id
+			join cart_product cp on cp.cart_id = c.id
+			where c.updated_at < $1 and (c.reminded_at is null or c.reminded_at < c.updated_at)
+			group by c.id, a.id
+			order by c.updated_at`
+	rows, err := s.db.Query(query, time.Now().Add(-idle))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	carts := []*types.AbandonedCart{}
+	for rows.Next() {
+		cart := new(types.AbandonedCart)
+		if err := rows.Scan(&cart.CartID, &cart.UserID, &cart.Email, &cart.FirstName, &cart.UpdatedAt, &cart.Items); err != nil {
+			return nil, err
+		}
+		carts = append(carts, cart)
+	}
+	return carts, rows.Err()
+}
+
+func (s *PostgresStore) MarkCartReminded(cartID int) error {
+	_, err := s.db.Exec(`update cart set reminded_at = now() where id = $1`, cartID)
+	return err
+}
+
+// GetCartAbandonmentStats counts the non-empty carts and how many of them
+// have been idle for longer than idle.
+func (s *PostgresStore) GetCartAbandonmentStats(idle time.Duration) (*types.CartAbandonmentStats, error) {
+	query := `select
+				count(*),
+				count(*) filter (where c.updated_at < $1),
+				coalesce(sum(c.value) filter (where c.updated_at < $1), 0),
+				count(*) filter (where c.reminded_at is not null),
+				count(*) filter (where c.reminded_at is not null and c.updated_at > c.reminded_at)
+			from (
+				select c.id, c.updated_at, c.reminded_at, sum(cp.quantity * p.price) as value
+				from cart c
+				join cart_product cp on cp.cart_id = c.id
+				join product p on p.id = cp.product_id
+				group by c.id
+			) c`
+	stats := &types.CartAbandonmentStats{Idle: idle.String()}
+	var value float64
+	err := s.db.QueryRow(query, time.Now().Add(-idle)).Scan(
+		&stats.Carts,
+		&stats.Abandoned,
+		&value,
+		&stats.Reminded,
+		&stats.Recovered,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stats.AbandonedValue = money.FromFloat(value)
+	if stats.Carts > 0 {
+		stats.AbandonmentRate = float64(stats.Abandoned) / float64(stats.Carts)
+	}
+	return stats, nil
+}
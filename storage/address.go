@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+	"fmt"
+)
+
+func (s *PostgresStore) CreateAddressTable() error {
+	query := `create table if not exists address(
+			id serial primary key,
+			accID integer references account(id) on delete cascade,
+			kind varchar(10) not null,
+			name varchar(100) not null,
+			line1 varchar(100) not null,
+			line2 varchar(100),
+			city varchar(100) not null,
+			region varchar(100),
+			postal_code varchar(20),
+			country varchar(2) not null,
+			phone varchar(30),
+			is_default boolean not null default false
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CreateOrderAddressTable holds copies of the addresses an order was placed
+// with, so editing the address book does not change past orders.
+func (s *PostgresStore) CreateOrderAddressTable() error {
+	query := `create table if not exists order_address(
+			orderID integer references orders(id) on delete cascade,
+			kind varchar(10) not null,
+			name varchar(100) not null,
+			line1 varchar(100) not null,
+			line2 varchar(100),
+			city varchar(100) not null,
+			region varchar(100),
+			postal_code varchar(20),
+			country varchar(2) not null,
+			phone varchar(30),
+			constraint order_address_pk primary key (orderID, kind)
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CreateAddress adds the address to its account's address book. The first
+// address of a kind becomes the default.
+func (s *PostgresStore) CreateAddress(addr *types.Address) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var others int
+	err = tx.QueryRow(`select count(*) from address where accID = $1 and kind = $2`, addr.AccID, addr.Kind).
+		Scan(&others)
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		addr.Default = true
+	}
+	if addr.Default {
+		if err := clearDefaultAddress(tx, addr.AccID, addr.Kind); err != nil {
+			return err
+		}
+	}
+	err = tx.QueryRow(`insert into address (accID, kind, name, line1, line2, city, region, postal_code, country, phone, is_default)
+			values ($1, $2, $3, $4, nullif($5, ''), $6, nullif($7, ''), nullif($8, ''), $9, nullif($10, ''), $11)
+			returning id`,
+		addressArgs(addr)...).Scan(&addr.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateAddress changes an address. A kind whose default was unset or moved
+// to the other kind gets its oldest address as the new default, like after
+// DeleteAddress.
+func (s *PostgresStore) UpdateAddress(addr *types.Address) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldKind types.AddressKind
+	err = tx.QueryRow(`select kind from address where accID = $1 and id = $2 for update`, addr.AccID, addr.ID).
+		Scan(&oldKind)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("address %d not found", addr.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if addr.Default {
+		if err := clearDefaultAddress(tx, addr.AccID, addr.Kind); err != nil {
+			return err
+		}
+	}
+	res, err := tx.Exec(`update address set kind = $2, name = $3, line1 = $4, line2 = nullif($5, ''), city = $6,
+				region = nullif($7, ''), postal_code = nullif($8, ''), country = $9, phone = nullif($10, ''),
+				is_default = $11
+			where accID = $1 and id = $12`,
+		append(addressArgs(addr), addr.ID)...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("address %d not found", addr.ID)
+	}
+	for _, kind := range []types.AddressKind{oldKind, addr.Kind} {
+		if err := ensureDefaultAddress(tx, addr.AccID, kind); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func insertOrderAddress(tx *sql.Tx, orderID int, kind types.AddressKind, addr *types.Address) error {
+	_, err := tx.Exec(`insert into order_address (orderID, kind, name, line1, line2, city, region, postal_code, country, phone)
+			values ($1, $2, $3, $4, nullif($5, ''), $6, nullif($7, ''), nullif($8, ''), $9, nullif($10, ''))`,
+		orderID, kind, addr.Name, addr.Line1, addr.Line2, addr.City, addr.Region, addr.PostalCode, addr.Country, addr.Phone)
+	return err
+}
+
+// attachOrderAddresses loads the shipping and billing address the order was
+// placed with.
+func (s *PostgresStore) attachOrderAddresses(order *types.Order) error {
+	rows, err := s.db.Query(`select kind, name, line1, coalesce(line2, ''), city, coalesce(region, ''),
+				coalesce(postal_code, ''), country, coalesce(phone, '')
+			from order_address where orderID = $1`, order.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		addr := &types.Address{AccID: order.AccID}
+		err := rows.Scan(
+			&addr.Kind,
+			&addr.Name,
+			&addr.Line1,
+			&addr.Line2,
+			&addr.City,
+			&addr.Region,
+			&addr.PostalCode,
+			&addr.Country,
+			&addr.Phone)
+		if err != nil {
+			return err
+		}
+		if addr.Kind == types.AddressBilling {
+			order.BillingAddress = addr
+		} else {
+			order.ShippingAddress = addr
+		}
+	}
+	return rows.Err()
+}
+
+func addressArgs(addr *types.Address) []any {
+	return []any{
+		addr.AccID,
+		addr.Kind,
+		addr.Name,
+		addr.Line1,
+		addr.Line2,
+		addr.City,
+		addr.Region,
+		addr.PostalCode,
+		addr.Country,
+		addr.Phone,
+		addr.Default,
+	}
+}
+
+func clearDefaultAddress(tx *sql.Tx, accID int, kind types.AddressKind) error {
+	_, err := tx.Exec(`update address set is_default = false where accID = $1 and kind = $2`, accID, kind)
+	return err
+}
+
+// DeleteAddress removes the address. If it was the default, the oldest
+// remaining address of its kind takes over.
+func (s *PostgresStore) DeleteAddress(accID, id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var kind types.AddressKind
+	var wasDefault bool
+	err = tx.QueryRow(`delete from address where accID = $1 and id = $2 returning kind, is_default`, accID, id).
+		Scan(&kind, &wasDefault)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("address %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	if wasDefault {
+		if err := ensureDefaultAddress(tx, accID, kind); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ensureDefaultAddress makes the oldest address of the kind the default if
+// the account has addresses of that kind but none is the default.
+func ensureDefaultAddress(tx *sql.Tx, accID int, kind types.AddressKind) error {
+	_, err := tx.Exec(`update address set is_default = true
+			where id = (select min(id) from address where accID = $1 and kind = $2)
+				and not exists(select 1 from address where accID = $1 and kind = $2 and is_default)`, accID, kind)
+	return err
+}
+
+const addressSelect = `select id, accID, kind, name, line1, coalesce(line2, ''), city, coalesce(region, ''),
+			coalesce(postal_code, ''), country, coalesce(phone, ''), is_default
+		from address`
+
+func (s *PostgresStore) GetAddresses(accID int) ([]*types.Address, error) {
+	return s.queryAddresses(addressSelect+` where accID = $1 order by kind, is_default desc, id`, accID)
+}
+
+func (s *PostgresStore) GetAddress(accID, id int) (*types.Address, error) {
+	addrs, err := s.queryAddresses(addressSelect+` where accID = $1 and id = $2`, accID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("address %d not found", id)
+	}
+	return addrs[0], nil
+}
+
+// GetDefaultAddress returns the account's default address of kind, or nil
+// if it has none.
+func (s *PostgresStore) GetDefaultAddress(accID int, kind types.AddressKind) (*types.Address, error) {
+	addrs, err := s.queryAddresses(addressSelect+` where accID = $1 and kind = $2 and is_default`, accID, kind)
+	if err != nil || len(addrs) == 0 {
+		return nil, err
+	}
+	return addrs[0], nil
+}
+
+func (s *PostgresStore) queryAddresses(query string, args ...any) ([]*types.Address, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addrs := []*types.Address{}
+	for rows.Next() {
+		addr, err := scanIntoAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, rows.Err()
+}
+
+func scanIntoAddress(rows *sql.Rows) (*types.Address, error) {
+	addr := new(types.Address)
+	err := rows.Scan(
+		&addr.ID,
+		&addr.AccID,
+		&addr.Kind,
+		&addr.Name,
+		&addr.Line1,
+		&addr.Line2,
+		&addr.City,
+		&addr.Region,
+		&addr.PostalCode,
+		&addr.Country,
+		&addr.Phone,
+		&addr.Default)
+	return addr, err
+}
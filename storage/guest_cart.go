@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"3legant/types"
+	"fmt"
+	"time"
+)
+
+// MigrateCartTable lets carts exist without an account. Such guest carts are
+// identified by guest_token instead of user_id.
+func (s *PostgresStore) MigrateCartTable() error {
+	query := `alter table cart
+			alter column user_id drop not null,
+			alter column user_id drop default,
+			add column if not exists guest_token varchar(64) unique,
+			add column if not exists created_at timestamp not null default now()`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateGuestCart(token string) (*types.Cart, error) {
+	cart := new(types.Cart)
+	err := s.db.QueryRow(`insert into cart (guest_token) values ($1) returning id`, token).Scan(&cart.CartID)
+	return cart, err
+}
+
+func (s *PostgresStore) GetCartByGuestToken(token string) (*types.Cart, error) {
+	rows, err := s.db.Query(cartSelect+` where guest_token = $1`, token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		return scanIntoCart(rows)
+	}
+	return nil, fmt.Errorf("cart not found")
+}
+
+// MergeCarts moves every item of the cart fromID into the cart toID and
+// deletes fromID. Quantities of products in both carts are summed but never
+// exceed the product's stock.
+func (s *PostgresStore) MergeCarts(fromID, toID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `insert into cart_product (cart_id, product_id, quantity, unit_price)
+			select $2, g.product_id, g.quantity, g.unit_price from cart_product g where g.cart_id = $1
+			on conflict (cart_id, product_id) do update set quantity = cart_product.quantity + excluded.quantity`
+	if _, err := tx.Exec(query, fromID, toID); err != nil {
+		return err
+	}
+	if err := clampCartToStock(tx, toID); err != nil {
+		return err
+	}
+	if err := touchCart(tx, toID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`delete from cart_product where cart_id = $1`, fromID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`delete from cart where id = $1`, fromID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// clampCartToStock lowers quantities above the available stock and drops
+// items that are out of stock.
+func clampCartToStock(db execer, cartID int) error {
+	_, err := db.Exec(`update cart_product cp set quantity = p.stock
+			from product p where cp.product_id = p.id and cp.cart_id = $1 and p.stock is not null and cp.quantity > p.stock`,
+		cartID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`delete from cart_product where cart_id = $1 and quantity <= 0`, cartID)
+	return err
+}
+
+// DeleteExpiredGuestCarts removes guest carts that were last changed more
+// than maxAge ago.
+func (s *PostgresStore) DeleteExpiredGuestCarts(maxAge time.Duration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cutoff := time.Now().Add(-maxAge)
+	expired := `select id from cart where user_id is null and updated_at < $1`
+	if _, err := tx.Exec(`delete from cart_product where cart_id in (`+expired+`)`, cutoff); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`delete from cart where id in (`+expired+`)`, cutoff); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+	"time"
+)
+
+func (s *PostgresStore) CreateIdempotencyKeyTable() error {
+	query := `create table if not exists idempotency_key(
+			scope varchar(255),
+			key varchar(255),
+			fingerprint varchar(64) not null,
+			status integer,
+			content_type varchar(100),
+			body bytea,
+			created_at timestamp not null,
+			expires_at timestamp not null,
+			constraint idempotency_key_pk primary key (scope, key)
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// ClaimIdempotencyKey stores rec as in flight unless its key is already
+// taken, in which case it returns the record holding the key. Expired
+// records and requests in flight for longer than stale are given up on, so
+// their key can be claimed again.
+func (s *PostgresStore) ClaimIdempotencyKey(rec *types.IdempotencyRecord, stale time.Duration) (*types.IdempotencyRecord, error) {
+	_, err := s.db.Exec(`delete from idempotency_key
+			where scope = $1 and key = $2 and (expires_at < $3 or (status is null and created_at < $4))`,
+		rec.Scope, rec.Key, rec.CreatedAt, rec.CreatedAt.Add(-stale))
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(`insert into idempotency_key (scope, key, fingerprint, created_at, expires_at)
+			values ($1, $2, $3, $4, $5) on conflict do nothing`,
+		rec.Scope, rec.Key, rec.Fingerprint, rec.CreatedAt, rec.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	held := new(types.IdempotencyRecord)
+	var status sql.NullInt64
+	var contentType sql.NullString
+	err = s.db.QueryRow(`select scope, key, fingerprint, status, content_type, body, created_at, expires_at
+			from idempotency_key where scope = $1 and key = $2`, rec.Scope, rec.Key).
+		Scan(&held.Scope, &held.Key, &held.Fingerprint, &status, &contentType, &held.Body, &held.CreatedAt, &held.ExpiresAt)
+	if err == sql.ErrNoRows {
+		// the holder gave the key up in the meantime
+		return s.ClaimIdempotencyKey(rec, stale)
+	}
+	if err != nil {
+		return nil, err
+	}
+	held.Status = int(status.Int64)
+	held.ContentType = contentType.String
+	return held, nil
+}
+
+// CompleteIdempotencyKey stores the response of a claimed request.
+func (s *PostgresStore) CompleteIdempotencyKey(rec *types.IdempotencyRecord) error {
+	_, err := s.db.Exec(`update idempotency_key set status = $3, content_type = $4, body = $5
+			where scope = $1 and key = $2`, rec.Scope, rec.Key, rec.Status, rec.ContentType, rec.Body)
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a claimed request, so a retry runs it again.
+func (s *PostgresStore) ReleaseIdempotencyKey(scope, key string) error {
+	_, err := s.db.Exec(`delete from idempotency_key where scope = $1 and key = $2`, scope, key)
+	return err
+}
+
+func (s *PostgresStore) DeleteExpiredIdempotencyKeys() error {
+	_, err := s.db.Exec(`delete from idempotency_key where expires_at < $1`, time.Now())
+	return err
+}
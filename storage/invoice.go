@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+)
+
+func (s *PostgresStore) CreateInvoiceTable() error {
+	query := `create table if not exists invoice(
+			id serial primary key,
+			seq integer unique not null,
+			orderID integer unique references orders(id),
+			issued_at timestamp not null default now()
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CreateInvoice issues the invoice of an order with the next number. An
+// order is only invoiced once; later calls return the existing invoice.
+func (s *PostgresStore) CreateInvoice(orderID int) (*types.Invoice, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// serialize numbering so two invoices never get the same number and a
+	// rolled back one leaves no gap
+	if _, err := tx.Exec(`lock table invoice in share row exclusive mode`); err != nil {
+		return nil, err
+	}
+	inv := new(types.Invoice)
+	err = tx.QueryRow(`select id, seq, orderID, issued_at from invoice where orderID = $1`, orderID).
+		Scan(&inv.ID, &inv.Seq, &inv.OrderID, &inv.IssuedAt)
+	if err == nil {
+		return inv, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	err = tx.QueryRow(`insert into invoice (seq, orderID)
+			select coalesce(max(seq), 0) + 1, $1 from invoice
+			returning id, seq, orderID, issued_at`, orderID).
+		Scan(&inv.ID, &inv.Seq, &inv.OrderID, &inv.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+	return inv, tx.Commit()
+}
+
+// GetOrderInvoice returns the invoice of the order, or nil if it has not
+// been invoiced.
+func (s *PostgresStore) GetOrderInvoice(orderID int) (*types.Invoice, error) {
+	inv := new(types.Invoice)
+	err := s.db.QueryRow(`select id, seq, orderID, issued_at from invoice where orderID = $1`, orderID).
+		Scan(&inv.ID, &inv.Seq, &inv.OrderID, &inv.IssuedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
@@ -0,0 +1,439 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrCheckoutConflict is returned when the cart or its products changed while
+// an order was being placed. Checking out again usually succeeds.
+var ErrCheckoutConflict = errors.New("checkout conflict")
+
+// ErrOrderChanged is returned when an order is no longer in the status a
+// transition expected.
+var ErrOrderChanged = errors.New("order status changed")
+
+func (s *PostgresStore) CreateOrderTable() error {
+	query := `create table if not exists orders(
+			id serial primary key,
+			accID integer references account(id) on delete set null,
+			status varchar(30) not null,
+			subtotal bigint not null,
+			discount bigint not null,
+			tax bigint not null,
+			shipping bigint not null,
+			total bigint not null,
+			created_at timestamp not null default now()
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// MigrateOrderTable adds where and how an order ships and whether its prices
+// include tax.
+func (s *PostgresStore) MigrateOrderTable() error {
+	query := `alter table orders
+			add column if not exists shipping_method varchar(20),
+			add column if not exists ship_country varchar(2),
+			add column if not exists ship_region varchar(100),
+			add column if not exists ship_postal_code varchar(20),
+			add column if not exists tax_included boolean not null default false`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateOrderLineTable() error {
+	query := `create table if not exists order_line(
+			id serial primary key,
+			orderID integer references orders(id) on delete cascade,
+			prodID integer references product(id),
+			name varchar(50),
+			quantity integer not null,
+			unit_price bigint not null,
+			discount bigint not null,
+			line_total bigint not null
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) MigrateOrderLineTable() error {
+	_, err := s.db.Exec(`alter table order_line add column if not exists tax bigint not null default 0`)
+	return err
+}
+
+func (s *PostgresStore) CreateOrderEventTable() error {
+	query := `create table if not exists order_event(
+			id serial primary key,
+			orderID integer references orders(id) on delete cascade,
+			from_status varchar(30),
+			to_status varchar(30) not null,
+			actorID integer references account(id) on delete set null,
+			note varchar(200),
+			created_at timestamp not null default now()
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateOrderAdjustmentTable() error {
+	query := `create table if not exists order_adjustment(
+			id serial primary key,
+			orderID integer references orders(id) on delete cascade,
+			promotionID integer references promotion(id) on delete set null,
+			code varchar(50),
+			description varchar(100),
+			amount bigint not null
+		)`
+
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`alter table promotion_redemption
+			add column if not exists orderID integer references orders(id) on delete set null`)
+	return err
+}
+
+// PlaceOrder stores the order, takes its products out of stock, redeems its
+// promotions and empties the cart it was placed from, all or nothing.
+func (s *PostgresStore) PlaceOrder(order *types.Order, cartID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkCartUnchanged(tx, cartID, order); err != nil {
+		return err
+	}
+	for _, line := range order.Lines {
+		if err := reserveStock(tx, line); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRow(`insert into orders (accID, status, subtotal, discount, tax, shipping, total,
+				shipping_method, ship_country, ship_region, ship_postal_code, tax_included)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, nullif($10, ''), $11, $12) returning id, created_at`,
+		order.AccID, order.Status, order.Subtotal, order.Discount, order.Tax, order.Shipping, order.Total,
+		order.ShippingMethod, order.Destination.Country, order.Destination.Region, order.Destination.PostalCode,
+		order.TaxIncluded).
+		Scan(&order.ID, &order.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if err := insertOrderEvent(tx, order.ID, "", order.Status, order.AccID, ""); err != nil {
+		return err
+	}
+	if order.ShippingAddress != nil {
+		if err := insertOrderAddress(tx, order.ID, types.AddressShipping, order.ShippingAddress); err != nil {
+			return err
+		}
+	}
+	if order.BillingAddress != nil {
+		if err := insertOrderAddress(tx, order.ID, types.AddressBilling, order.BillingAddress); err != nil {
+			return err
+		}
+	}
+	for _, line := range order.Lines {
+		err := tx.QueryRow(`insert into order_line (orderID, prodID, name, quantity, unit_price, discount, line_total, tax)
+				values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`,
+			order.ID, line.ProdID, line.Name, line.Quantity, line.UnitPrice, line.Discount, line.LineTotal, line.Tax).
+			Scan(&line.ID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range order.Discounts {
+		_, err := tx.Exec(`insert into order_adjustment (orderID, promotionID, code, description, amount)
+				values ($1, nullif($2, 0), nullif($3, ''), $4, $5)`,
+			order.ID, d.PromotionID, d.Code, d.Description, d.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	if err := insertOrderTaxes(tx, order); err != nil {
+		return err
+	}
+	if err := insertEvent(tx, types.EventOrderCreated, order); err != nil {
+		return err
+	}
+	for _, promoID := range order.Promotions {
+		if err := redeemPromotion(tx, promoID, order); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`delete from cart_product where cart_id = $1`, cartID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`delete from cart_coupon where cart_id = $1`, cartID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkCartUnchanged locks the cart items and makes sure they are still the
+// ones the order was priced from.
+func checkCartUnchanged(tx *sql.Tx, cartID int, order *types.Order) error {
+	rows, err := tx.Query(`select product_id, quantity from cart_product where cart_id = $1 for update`, cartID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	quantities := map[int]int{}
+	for rows.Next() {
+		var prodID, quantity int
+		if err := rows.Scan(&prodID, &quantity); err != nil {
+			return err
+		}
+		quantities[prodID] = quantity
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(quantities) != len(order.Lines) {
+		return fmt.Errorf("%w: the cart changed", ErrCheckoutConflict)
+	}
+	for _, line := range order.Lines {
+		if quantities[line.ProdID] != line.Quantity {
+			return fmt.Errorf("%w: the cart changed", ErrCheckoutConflict)
+		}
+	}
+	return nil
+}
+
+func reserveStock(tx *sql.Tx, line *types.OrderLine) error {
+	product, err := lockProduct(tx, line.ProdID)
+	if err != nil {
+		return fmt.Errorf("%w: %s is no longer available", ErrCheckoutConflict, line.Name)
+	}
+	if product.price != line.UnitPrice {
+		return fmt.Errorf("%w: the price of %s changed", ErrCheckoutConflict, line.Name)
+	}
+	if !product.stock.Valid {
+		return nil
+	}
+	if int64(line.Quantity) > product.stock.Int64 {
+		return fmt.Errorf("%w: only %d of %s in stock", ErrCheckoutConflict, product.stock.Int64, line.Name)
+	}
+	_, err = tx.Exec(`update product set stock = stock - $2 where id = $1`, line.ProdID, line.Quantity)
+	return err
+}
+
+func redeemPromotion(tx *sql.Tx, promoID int, order *types.Order) error {
+	res, err := tx.Exec(`update promotion set times_used = times_used + 1
+			where id = $1 and (usage_limit = 0 or times_used < usage_limit)
+			and (per_customer_limit = 0 or per_customer_limit >
+				(select count(*) from promotion_redemption where promotionID = $1 and accID = $2))`,
+		promoID, order.AccID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%w: a promotion is no longer available", ErrCheckoutConflict)
+	}
+	_, err = tx.Exec(`insert into promotion_redemption (promotionID, accID, orderID) values ($1, $2, $3)`,
+		promoID, order.AccID, order.ID)
+	return err
+}
+
+// TransitionOrder moves the order from one status to another and records who
+// did it. Cancelling an order puts its products back in stock.
+func (s *PostgresStore) TransitionOrder(id int, from, to types.OrderStatus, actorID int, note string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`update orders set status = $3 where id = $1 and status = $2`, id, from, to)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%w: order %d is no longer %s", ErrOrderChanged, id, from)
+	}
+	if err := insertOrderEvent(tx, id, from, to, actorID, note); err != nil {
+		return err
+	}
+	var accID sql.NullInt64
+	if err := tx.QueryRow(`select accID from orders where id = $1`, id).Scan(&accID); err != nil {
+		return err
+	}
+	change := &types.OrderStatusChange{OrderID: id, AccID: int(accID.Int64), From: from, To: to, Note: note}
+	if err := insertEvent(tx, types.EventOrderStatusChanged, change); err != nil {
+		return err
+	}
+	if to == types.OrderCancelled {
+		if err := releaseStock(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func insertOrderEvent(tx *sql.Tx, orderID int, from, to types.OrderStatus, actorID int, note string) error {
+	_, err := tx.Exec(`insert into order_event (orderID, from_status, to_status, actorID, note)
+			values ($1, nullif($2, ''), $3, nullif($4, 0), nullif($5, ''))`,
+		orderID, from, to, actorID, note)
+	return err
+}
+
+func releaseStock(db execer, orderID int) error {
+	_, err := db.Exec(`update product p set stock = p.stock + l.quantity
+			from order_line l where l.orderID = $1 and l.prodID = p.id and p.stock is not null`, orderID)
+	return err
+}
+
+const orderSelect = `select id, coalesce(accID, 0), status, subtotal, discount, tax, shipping, total, created_at,
+			coalesce(shipping_method, ''), coalesce(ship_country, ''), coalesce(ship_region, ''),
+			coalesce(ship_postal_code, ''), tax_included
+		from orders`
+
+func (s *PostgresStore) GetOrderByID(id int) (*types.Order, error) {
+	orders, err := s.queryOrders(orderSelect+` where id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("order %d not found", id)
+	}
+	return orders[0], nil
+}
+
+// GetOrders returns the orders matching q, newest first. Zero fields of q
+// match every order.
+func (s *PostgresStore) GetOrders(q *types.OrderQuery) ([]*types.Order, error) {
+	return s.queryOrders(orderSelect+` where ($1 = 0 or accID = $1) and ($2 = '' or status = $2)
+			order by created_at desc, id desc`, q.AccID, q.Status)
+}
+
+func (s *PostgresStore) queryOrders(query string, args ...any) ([]*types.Order, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*types.Order{}
+	for rows.Next() {
+		order, err := scanIntoOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		if err := s.attachOrderLines(order); err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+func scanIntoOrder(rows *sql.Rows) (*types.Order, error) {
+	order := new(types.Order)
+	err := rows.Scan(
+		&order.ID,
+		&order.AccID,
+		&order.Status,
+		&order.Subtotal,
+		&order.Discount,
+		&order.Tax,
+		&order.Shipping,
+		&order.Total,
+		&order.CreatedAt,
+		&order.ShippingMethod,
+		&order.Destination.Country,
+		&order.Destination.Region,
+		&order.Destination.PostalCode,
+		&order.TaxIncluded)
+	return order, err
+}
+
+// attachOrderLines loads the lines, discounts, taxes, addresses, status
+// history, payments and returns of the order.
+func (s *PostgresStore) attachOrderLines(order *types.Order) error {
+	rows, err := s.db.Query(`select id, coalesce(prodID, 0), name, quantity, unit_price, discount, line_total, tax
+			from order_line where orderID = $1 order by id`, order.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	order.Lines = []*types.OrderLine{}
+	for rows.Next() {
+		line := new(types.OrderLine)
+		err := rows.Scan(&line.ID, &line.ProdID, &line.Name, &line.Quantity, &line.UnitPrice, &line.Discount, &line.LineTotal,
+			&line.Tax)
+		if err != nil {
+			return err
+		}
+		order.Lines = append(order.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	adjustments, err := s.db.Query(`select coalesce(code, ''), description, amount
+			from order_adjustment where orderID = $1 order by id`, order.ID)
+	if err != nil {
+		return err
+	}
+	defer adjustments.Close()
+
+	order.Discounts = []*types.CartAdjustment{}
+	for adjustments.Next() {
+		d := new(types.CartAdjustment)
+		if err := adjustments.Scan(&d.Code, &d.Description, &d.Amount); err != nil {
+			return err
+		}
+		order.Discounts = append(order.Discounts, d)
+	}
+	if err := adjustments.Err(); err != nil {
+		return err
+	}
+
+	if err := s.attachOrderTaxes(order); err != nil {
+		return err
+	}
+	if err := s.attachOrderAddresses(order); err != nil {
+		return err
+	}
+
+	events, err := s.db.Query(`select coalesce(from_status, ''), to_status, coalesce(actorID, 0), coalesce(note, ''), created_at
+			from order_event where orderID = $1 order by created_at, id`, order.ID)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	order.History = []*types.OrderEvent{}
+	for events.Next() {
+		e := new(types.OrderEvent)
+		if err := events.Scan(&e.From, &e.To, &e.ActorID, &e.Note, &e.CreatedAt); err != nil {
+			return err
+		}
+		order.History = append(order.History, e)
+	}
+	if err := events.Err(); err != nil {
+		return err
+	}
+
+	if order.Payments, err = s.GetOrderPayments(order.ID); err != nil {
+		return err
+	}
+	order.Returns, err = s.GetOrderReturns(order.ID)
+	return err
+}
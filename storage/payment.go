@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"3legant/money"
+	"3legant/payments"
+	"3legant/types"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *PostgresStore) CreatePaymentTable() error {
+	query := `create table if not exists payment(
+			id serial primary key,
+			orderID integer references orders(id) on delete cascade,
+			intent_id varchar(100) unique not null,
+			status varchar(30) not null,
+			amount bigint not null,
+			refunded bigint not null default 0,
+			next_action varchar(200),
+			decline_reason varchar(100),
+			created_at timestamp not null default now()
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// ErrPaymentInProgress is returned when an order already has a payment that
+// was authorized or captured.
+var ErrPaymentInProgress = errors.New("payment in progress")
+
+// CreatePayment stores a payment attempt. The order is locked while doing so
+// and must still await payment without another payment going through, so
+// concurrent attempts cannot both charge the customer.
+func (s *PostgresStore) CreatePayment(payment *types.Payment) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status types.OrderStatus
+	err = tx.QueryRow(`select status from orders where id = $1 for update`, payment.OrderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("order %d not found", payment.OrderID)
+	}
+	if err != nil {
+		return err
+	}
+	if status != types.OrderPendingPayment {
+		return fmt.Errorf("%w: order %d is %s", ErrOrderChanged, payment.OrderID, status)
+	}
+	var open bool
+	err = tx.QueryRow(`select exists(select 1 from payment where orderID = $1 and status in ($2, $3))`,
+		payment.OrderID, payments.IntentAuthorized, payments.IntentCaptured).Scan(&open)
+	if err != nil {
+		return err
+	}
+	if open {
+		return fmt.Errorf("%w: order %d is already being paid", ErrPaymentInProgress, payment.OrderID)
+	}
+
+	query := `insert into payment (orderID, intent_id, status, amount, next_action, decline_reason)
+			values ($1, $2, $3, $4, nullif($5, ''), nullif($6, '')) returning id, created_at`
+	err = tx.QueryRow(query,
+		payment.OrderID,
+		payment.IntentID,
+		payment.Status,
+		payment.Amount,
+		payment.NextAction,
+		payment.DeclineReason).Scan(&payment.ID, &payment.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePaymentStatus moves the payment from one status to another and
+// fails if it is no longer in from, so concurrent webhooks act only once.
+func (s *PostgresStore) UpdatePaymentStatus(id int, from, to, reason string) error {
+	res, err := s.db.Exec(`update payment set status = $3, next_action = null,
+				decline_reason = coalesce(nullif($4, ''), decline_reason)
+			where id = $1 and status = $2`, id, from, to, reason)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("payment %d is no longer %s", id, from)
+	}
+	return nil
+}
+
+// AddPaymentRefund records a refund of amount. The total refunded never
+// exceeds what was paid.
+func (s *PostgresStore) AddPaymentRefund(id int, amount money.Amount) error {
+	res, err := s.db.Exec(`update payment set refunded = refunded + $2
+			where id = $1 and refunded + $2 <= amount`, id, amount)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("cannot refund %s of payment %d", amount, id)
+	}
+	return nil
+}
+
+const paymentSelect = `select id, orderID, intent_id, status, amount, refunded, coalesce(next_action, ''),
+			coalesce(decline_reason, ''), created_at
+		from payment`
+
+func (s *PostgresStore) GetPaymentByIntent(intentID string) (*types.Payment, error) {
+	payments, err := s.queryPayments(paymentSelect+` where intent_id = $1`, intentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("payment %s not found", intentID)
+	}
+	return payments[0], nil
+}
+
+func (s *PostgresStore) GetOrderPayments(orderID int) ([]*types.Payment, error) {
+	return s.queryPayments(paymentSelect+` where orderID = $1 order by id`, orderID)
+}
+
+// GetOrdersAwaitingRelease returns the cancelled and refunded orders that
+// still hold authorized payments or captured money that was not refunded,
+// once their status has not changed for settle.
+func (s *PostgresStore) GetOrdersAwaitingRelease(settle time.Duration) ([]*types.Order, error) {
+	return s.queryOrders(orderSelect+` o where o.status in ($1, $2)
+			and exists(select 1 from payment p where p.orderID = o.id
+				and (p.status = $3 or (p.status = $4 and p.refunded < p.amount)))
+			and (select max(created_at) from order_event where orderID = o.id) < now() - make_interval(secs => $5)
+			order by o.id`,
+		types.OrderCancelled, types.OrderRefunded, payments.IntentAuthorized, payments.IntentCaptured,
+		int(settle.Seconds()))
+}
+
+func (s *PostgresStore) queryPayments(query string, args ...any) ([]*types.Payment, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*types.Payment{}
+	for rows.Next() {
+		payment, err := scanIntoPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+func scanIntoPayment(rows *sql.Rows) (*types.Payment, error) {
+	payment := new(types.Payment)
+	err := rows.Scan(
+		&payment.ID,
+		&payment.OrderID,
+		&payment.IntentID,
+		&payment.Status,
+		&payment.Amount,
+		&payment.Refunded,
+		&payment.NextAction,
+		&payment.DeclineReason,
+		&payment.CreatedAt)
+	return payment, err
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+func (s *PostgresStore) CreatePromotionTable() error {
+	query := `create table if not exists promotion(
+			id serial primary key,
+			name varchar(100),
+			code varchar(50) unique,
+			kind varchar(20),
+			percent integer not null default 0,
+			amount_off bigint not null default 0,
+			buy_quantity integer not null default 0,
+			get_quantity integer not null default 0,
+			min_subtotal bigint not null default 0,
+			category varchar(50),
+			usage_limit integer not null default 0,
+			per_customer_limit integer not null default 0,
+			times_used integer not null default 0,
+			starts_at timestamp,
+			ends_at timestamp,
+			active boolean not null default true
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreatePromotionRedemptionTable() error {
+	query := `create table if not exists promotion_redemption(
+			id serial primary key,
+			promotionID integer references promotion(id) on delete cascade,
+			accID integer references account(id) on delete set null,
+			created_at timestamp not null default now()
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CreateCartCouponTable holds the coupon applied to a cart; a cart can have
+// at most one.
+func (s *PostgresStore) CreateCartCouponTable() error {
+	query := `create table if not exists cart_coupon(
+			cart_id integer primary key references cart(id) on delete cascade,
+			promotionID integer references promotion(id) on delete cascade
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+const promotionSelect = `select id, name, coalesce(code, ''), kind, percent, amount_off, buy_quantity, get_quantity,
+			min_subtotal, coalesce(category, ''), usage_limit, per_customer_limit, times_used, starts_at, ends_at, active
+		from promotion`
+
+func (s *PostgresStore) CreatePromotion(promo *types.Promotion) error {
+	query := `insert into promotion (name, code, kind, percent, amount_off, buy_quantity, get_quantity,
+				min_subtotal, category, usage_limit, per_customer_limit, starts_at, ends_at, active)
+			values ($1, nullif($2, ''), $3, $4, $5, $6, $7, $8, nullif($9, ''), $10, $11, $12, $13, $14)
+			returning id`
+	return s.db.QueryRow(query, promotionArgs(promo)...).Scan(&promo.ID)
+}
+
+func (s *PostgresStore) UpdatePromotion(id int, promo *types.Promotion) error {
+	query := `update promotion set name = $1, code = nullif($2, ''), kind = $3, percent = $4, amount_off = $5,
+				buy_quantity = $6, get_quantity = $7, min_subtotal = $8, category = nullif($9, ''),
+				usage_limit = $10, per_customer_limit = $11, starts_at = $12, ends_at = $13, active = $14
+			where id = $15`
+	res, err := s.db.Exec(query, append(promotionArgs(promo), id)...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("promotion %d not found", id)
+	}
+	return nil
+}
+
+func promotionArgs(promo *types.Promotion) []any {
+	return []any{
+		promo.Name,
+		promo.Code,
+		promo.Kind,
+		promo.Percent,
+		promo.AmountOff,
+		promo.BuyQuantity,
+		promo.GetQuantity,
+		promo.MinSubtotal,
+		promo.Category,
+		promo.UsageLimit,
+		promo.PerCustomerLimit,
+		promo.StartsAt,
+		promo.EndsAt,
+		promo.Active,
+	}
+}
+
+func (s *PostgresStore) DeletePromotion(id int) error {
+	res, err := s.db.Exec(`delete from promotion where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("promotion %d not found", id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetPromotions() ([]*types.Promotion, error) {
+	return s.queryPromotions(promotionSelect + ` order by id`)
+}
+
+func (s *PostgresStore) GetPromotionByID(id int) (*types.Promotion, error) {
+	promos, err := s.queryPromotions(promotionSelect+` where id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(promos) == 0 {
+		return nil, fmt.Errorf("promotion %d not found", id)
+	}
+	return promos[0], nil
+}
+
+func (s *PostgresStore) GetPromotionByCode(code string) (*types.Promotion, error) {
+	promos, err := s.queryPromotions(promotionSelect+` where code = $1`, code)
+	if err != nil {
+		return nil, err
+	}
+	if len(promos) == 0 {
+		return nil, fmt.Errorf("coupon %s not found", code)
+	}
+	return promos[0], nil
+}
+
+// GetAutomaticPromotions returns the active promotions that need no coupon.
+func (s *PostgresStore) GetAutomaticPromotions() ([]*types.Promotion, error) {
+	return s.queryPromotions(promotionSelect + ` where code is null and active order by id`)
+}
+
+func (s *PostgresStore) queryPromotions(query string, args ...any) ([]*types.Promotion, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promos := []*types.Promotion{}
+	for rows.Next() {
+		promo, err := scanIntoPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		promos = append(promos, promo)
+	}
+	return promos, rows.Err()
+}
+
+func scanIntoPromotion(rows *sql.Rows) (*types.Promotion, error) {
+	promo := new(types.Promotion)
+	var startsAt, endsAt sql.NullTime
+	err := rows.Scan(
+		&promo.ID,
+		&promo.Name,
+		&promo.Code,
+		&promo.Kind,
+		&promo.Percent,
+		&promo.AmountOff,
+		&promo.BuyQuantity,
+		&promo.GetQuantity,
+		&promo.MinSubtotal,
+		&promo.Category,
+		&promo.UsageLimit,
+		&promo.PerCustomerLimit,
+		&promo.TimesUsed,
+		&startsAt,
+		&endsAt,
+		&promo.Active)
+	if startsAt.Valid {
+		promo.StartsAt = &startsAt.Time
+	}
+	if endsAt.Valid {
+		promo.EndsAt = &endsAt.Time
+	}
+	return promo, err
+}
+
+func (s *PostgresStore) SetCartCoupon(cartID, promotionID int) error {
+	_, err := s.db.Exec(`insert into cart_coupon (cart_id, promotionID) values ($1, $2)
+			on conflict (cart_id) do update set promotionID = excluded.promotionID`, cartID, promotionID)
+	return err
+}
+
+func (s *PostgresStore) DeleteCartCoupon(cartID int) error {
+	_, err := s.db.Exec(`delete from cart_coupon where cart_id = $1`, cartID)
+	return err
+}
+
+// GetCartCoupon returns the promotion of the coupon applied to the cart, or
+// nil if there is none.
+func (s *PostgresStore) GetCartCoupon(cartID int) (*types.Promotion, error) {
+	promos, err := s.queryPromotions(promotionSelect+` where id = (select promotionID from cart_coupon where cart_id = $1)`,
+		cartID)
+	if err != nil || len(promos) == 0 {
+		return nil, err
+	}
+	return promos[0], nil
+}
+
+// CountRedemptions returns how often accID has used the promotion.
+func (s *PostgresStore) CountRedemptions(promotionID, accID int) (int, error) {
+	var n int
+	err := s.db.QueryRow(`select count(*) from promotion_redemption where promotionID = $1 and accID = $2`,
+		promotionID, accID).Scan(&n)
+	return n, err
+}
+
+// GetProductCategories returns the category names of each of prodIDs.
+func (s *PostgresStore) GetProductCategories(prodIDs []int) (map[int][]string, error) {
+	rows, err := s.db.Query(`select prodID, category_name from product_category where prodID = any($1)`,
+		pq.Array(prodIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := map[int][]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		categories[id] = append(categories[id], name)
+	}
+	return categories, rows.Err()
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"database/sql"
+)
+
+func (s *PostgresStore) CreateProductRatingTable() error {
+	query := `create table if not exists product_rating(
+			prodID integer primary key references product(id) on delete cascade,
+			review_count integer not null default 0,
+			average_rating real not null default 0,
+			star1 integer not null default 0,
+			star2 integer not null default 0,
+			star3 integer not null default 0,
+			star4 integer not null default 0,
+			star5 integer not null default 0
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// refreshProductRating recomputes the rating aggregate of a single product
+// from its reviews. It is called whenever a review is created, changed or
+// removed so that product listings never have to scan the review table.
+func refreshProductRating(db execer, prodID int) error {
+	query := `insert into product_rating
+			(prodID, review_count, average_rating, star1, star2, star3, star4, star5)
+			select $1,
+				count(*),
+				coalesce(avg(rating_given), 0),
+				count(*) filter (where star = 1),
+				count(*) filter (where star = 2),
+				count(*) filter (where star = 3),
+				count(*) filter (where star = 4),
+				count(*) filter (where star = 5)
+			from (
+				select rating_given, least(greatest(round(rating_given), 1), 5) star
+				from review where prodID = $1 and status = 'approved'
+			) r
+			on conflict (prodID) do update set
+				review_count = excluded.review_count,
+				average_rating = excluded.average_rating,
+				star1 = excluded.star1,
+				star2 = excluded.star2,
+				star3 = excluded.star3,
+				star4 = excluded.star4,
+				star5 = excluded.star5`
+	_, err := db.Exec(query, prodID)
+	return err
+}
+
+// RefreshProductRatings rebuilds the aggregates of every product, e.g. after
+// reviews were imported directly into the database.
+func (s *PostgresStore) RefreshProductRatings() error {
+	rows, err := s.db.Query(`select id from product`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := refreshProductRating(s.db, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
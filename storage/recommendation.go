@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"3legant/types"
+)
+
+// Weights used when scoring how related two products are.
+const (
+	relationWeightCategory     = 3.0
+	relationWeightPackaging    = 1.0
+	relationWeightMeasurements = 1.0
+	relationWeightTogether     = 2.0
+)
+
+func (s *PostgresStore) CreateProductRelationTable() error {
+	query := `create table if not exists product_relation(
+			prodID integer references product(id) on delete cascade,
+			relatedID integer references product(id) on delete cascade,
+			score real,
+			times_bought_together integer,
+			constraint product_relation_pk primary key (prodID, relatedID)
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// RefreshProductRelations recomputes the product_relation table from shared
+// categories, shared attributes and co-occurrence in carts and in orders
+// that were paid. Carts are emptied at checkout, so orders are what keeps
+// products bought together related.
+func (s *PostgresStore) RefreshProductRelations() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`delete from product_relation`); err != nil {
+		return err
+	}
+	query := `insert into product_relation (prodID, relatedID, score, times_bought_together)
+			select a, b, sum(score), sum(together) from (
+				select pc1.prodID a, pc2.prodID b, $1::real score, 0 together
+				from product_category pc1
+				join product_category pc2 on pc1.category_name = pc2.category_name and pc1.prodID <> pc2.prodID
+				union all
+				select p1.id, p2.id, $2::real, 0
+				from product p1
+				join product p2 on p1.packaging = p2.packaging and p1.id <> p2.id
+				where p1.packaging <> ''
+				union all
+				select p1.id, p2.id, $3::real, 0
+				from product p1
+				join product p2 on p1.measurements = p2.measurements and p1.id <> p2.id
+				where p1.measurements <> ''
+				union all
+				select cp1.product_id, cp2.product_id, $4::real, 1
+				from cart_product cp1
+				join cart_product cp2 on cp1.cart_id = cp2.cart_id and cp1.product_id <> cp2.product_id
+				union all
+				select l1.prodID, l2.prodID, $4::real, 1
+				from order_line l1
+				join order_line l2 on l1.orderID = l2.orderID and l1.prodID <> l2.prodID
+				join orders o on o.id = l1.orderID
+				where o.status not in ($5, $6)
+			) pairs
+			group by a, b`
+	if _, err := tx.Exec(query,
+		relationWeightCategory,
+		relationWeightPackaging,
+		relationWeightMeasurements,
+		relationWeightTogether,
+		types.OrderPendingPayment,
+		types.OrderCancelled,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRelatedProducts returns up to limit products related to prodID. When
+// together is set only products that were bought together are returned,
+// ordered by how often that happened.
+func (s *PostgresStore) GetRelatedProducts(prodID, limit int, together bool) ([]*types.RelatedProduct, error) {
+	query := `select relatedID, score, times_bought_together from product_relation
+			where prodID = $1 order by score desc, relatedID limit $2`
+	if together {
+		query = `select relatedID, score, times_bought_together from product_relation
+			where prodID = $1 and times_bought_together > 0
+			order by times_bought_together desc, score desc, relatedID limit $2`
+	}
+	rows, err := s.db.Query(query, prodID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type relation struct {
+		id       int
+		score    float64
+		together int
+	}
+	relations := []relation{}
+	for rows.Next() {
+		var rel relation
+		if err := rows.Scan(&rel.id, &rel.score, &rel.together); err != nil {
+			return nil, err
+		}
+		relations = append(relations, rel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	related := []*types.RelatedProduct{}
+	for _, rel := range relations {
+		product, err := s.GetProductByID(rel.id)
+		if err != nil {
+			return nil, err
+		}
+		if product.Archived {
+			continue
+		}
+		related = append(related, &types.RelatedProduct{
+			Product:             product,
+			Score:               rel.score,
+			TimesBoughtTogether: rel.together,
+		})
+	}
+	return related, nil
+}
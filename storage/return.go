@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"3legant/money"
+	"3legant/types"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrReturnChanged is returned when a return is no longer in the status an
+// update expected.
+var ErrReturnChanged = errors.New("return status changed")
+
+func (s *PostgresStore) CreateReturnTable() error {
+	query := `create table if not exists order_return(
+			id serial primary key,
+			orderID integer references orders(id) on delete cascade,
+			accID integer references account(id) on delete set null,
+			status varchar(20) not null,
+			note varchar(500),
+			label varchar(100),
+			rejection_reason varchar(200),
+			refund_amount bigint not null default 0,
+			created_at timestamp not null default now(),
+			updated_at timestamp not null default now()
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateReturnLineTable() error {
+	query := `create table if not exists return_line(
+			id serial primary key,
+			returnID integer references order_return(id) on delete cascade,
+			order_lineID integer references order_line(id) on delete cascade,
+			quantity integer not null,
+			reason varchar(200)
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CreateReturn stores a return after checking that no line returns more than
+// was ordered, counting earlier returns that were not rejected.
+func (s *PostgresStore) CreateReturn(ret *types.Return) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serialize returns of the same order
+	if _, err := tx.Exec(`select id from orders where id = $1 for update`, ret.OrderID); err != nil {
+		return err
+	}
+	// lines of the same order line count together
+	requested := map[int]int{}
+	for _, line := range ret.Lines {
+		requested[line.OrderLineID] += line.Quantity
+	}
+	for _, line := range ret.Lines {
+		var ordered, returned int
+		err := tx.QueryRow(`select l.quantity, coalesce(l.prodID, 0), coalesce((
+					select sum(rl.quantity) from return_line rl join order_return r on r.id = rl.returnID
+					where rl.order_lineID = l.id and r.status <> 'rejected'), 0)
+				from order_line l where l.id = $1 and l.orderID = $2`,
+			line.OrderLineID, ret.OrderID).Scan(&ordered, &line.ProdID, &returned)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("order line %d not found", line.OrderLineID)
+		}
+		if err != nil {
+			return err
+		}
+		if requested[line.OrderLineID] > ordered-returned {
+			return fmt.Errorf("only %d of order line %d can be returned", ordered-returned, line.OrderLineID)
+		}
+	}
+
+	err = tx.QueryRow(`insert into order_return (orderID, accID, status, note)
+			values ($1, $2, $3, nullif($4, '')) returning id, created_at, updated_at`,
+		ret.OrderID, ret.AccID, ret.Status, ret.Note).Scan(&ret.ID, &ret.CreatedAt, &ret.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	for _, line := range ret.Lines {
+		_, err := tx.Exec(`insert into return_line (returnID, order_lineID, quantity, reason) values ($1, $2, $3, $4)`,
+			ret.ID, line.OrderLineID, line.Quantity, line.Reason)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) ApproveReturn(id int, label string) error {
+	return s.updateReturn(id, types.ReturnRequested, types.ReturnApproved, `label = $4`, label)
+}
+
+func (s *PostgresStore) RejectReturn(id int, reason string) error {
+	return s.updateReturn(id, types.ReturnRequested, types.ReturnRejected, `rejection_reason = $4`, reason)
+}
+
+// ReceiveReturn marks the returned goods as arrived and puts them back in
+// stock.
+func (s *PostgresStore) ReceiveReturn(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setReturnStatus(tx, id, types.ReturnApproved, types.ReturnReceived, ``); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`update product p set stock = p.stock + rl.quantity
+			from return_line rl join order_line l on l.id = rl.order_lineID
+			where rl.returnID = $1 and l.prodID = p.id and p.stock is not null`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RefundReturn marks a received return refunded, adding amount to what an
+// earlier, partly failed refund of it paid back.
+func (s *PostgresStore) RefundReturn(id int, amount money.Amount) error {
+	return s.updateReturn(id, types.ReturnReceived, types.ReturnRefunded, `refund_amount = refund_amount + $4`, amount)
+}
+
+// RevertReturnRefund takes back the unpaid part of RefundReturn when not all
+// of the money could be paid back. The return is received again, so the
+// rest can be refunded later, and keeps what was paid in its refund amount.
+func (s *PostgresStore) RevertReturnRefund(id int, unpaid money.Amount) error {
+	return s.updateReturn(id, types.ReturnRefunded, types.ReturnReceived, `refund_amount = refund_amount - $4`, unpaid)
+}
+
+func (s *PostgresStore) updateReturn(id int, from, to types.ReturnStatus, set string, arg any) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setReturnStatus(tx, id, from, to, set, arg); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setReturnStatus(tx *sql.Tx, id int, from, to types.ReturnStatus, set string, args ...any) error {
+	query := `update order_return set status = $3, updated_at = now()`
+	if set != "" {
+		query += `, ` + set
+	}
+	query += ` where id = $1 and status = $2`
+	res, err := tx.Exec(query, append([]any{id, from, to}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%w: return %d is no longer %s", ErrReturnChanged, id, from)
+	}
+	return nil
+}
+
+const returnSelect = `select id, orderID, coalesce(accID, 0), status, coalesce(note, ''), coalesce(label, ''),
+			coalesce(rejection_reason, ''), refund_amount, created_at, updated_at
+		from order_return`
+
+func (s *PostgresStore) GetReturnByID(id int) (*types.Return, error) {
+	returns, err := s.queryReturns(returnSelect+` where id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(returns) == 0 {
+		return nil, fmt.Errorf("return %d not found", id)
+	}
+	return returns[0], nil
+}
+
+// GetReturns returns the returns in status, or all of them if status is
+// empty, oldest first.
+func (s *PostgresStore) GetReturns(status types.ReturnStatus) ([]*types.Return, error) {
+	return s.queryReturns(returnSelect+` where ($1 = '' or status = $1) order by created_at, id`, status)
+}
+
+func (s *PostgresStore) GetOrderReturns(orderID int) ([]*types.Return, error) {
+	return s.queryReturns(returnSelect+` where orderID = $1 order by created_at, id`, orderID)
+}
+
+func (s *PostgresStore) queryReturns(query string, args ...any) ([]*types.Return, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	returns := []*types.Return{}
+	for rows.Next() {
+		ret, err := scanIntoReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+		returns = append(returns, ret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, ret := range returns {
+		if err := s.attachReturnLines(ret); err != nil {
+			return nil, err
+		}
+	}
+	return returns, nil
+}
+
+func scanIntoReturn(rows *sql.Rows) (*types.Return, error) {
+	ret := new(types.Return)
+	err := rows.Scan(
+		&ret.ID,
+		&ret.OrderID,
+		&ret.AccID,
+		&ret.Status,
+		&ret.Note,
+		&ret.Label,
+		&ret.RejectionReason,
+		&ret.RefundAmount,
+		&ret.CreatedAt,
+		&ret.UpdatedAt)
+	return ret, err
+}
+
+func (s *PostgresStore) attachReturnLines(ret *types.Return) error {
+	rows, err := s.db.Query(`select rl.order_lineID, coalesce(l.prodID, 0), rl.quantity, coalesce(rl.reason, '')
+			from return_line rl join order_line l on l.id = rl.order_lineID
+			where rl.returnID = $1 order by rl.id`, ret.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ret.Lines = []*types.ReturnLine{}
+	for rows.Next() {
+		line := new(types.ReturnLine)
+		if err := rows.Scan(&line.OrderLineID, &line.ProdID, &line.Quantity, &line.Reason); err != nil {
+			return err
+		}
+		ret.Lines = append(ret.Lines, line)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+	"fmt"
+)
+
+func (s *PostgresStore) CreateReviewVoteTable() error {
+	query := `create table if not exists review_vote(
+			reviewID integer references review(id) on delete cascade,
+			accID integer references account(id) on delete cascade,
+			helpful boolean not null,
+			constraint review_vote_pk primary key (reviewID, accID)
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateReviewReportTable() error {
+	query := `create table if not exists review_report(
+			id serial primary key,
+			reviewID integer references review(id) on delete cascade,
+			accID integer references account(id) on delete cascade,
+			reason varchar(200),
+			created_at timestamp not null default now(),
+			resolved boolean not null default false,
+			constraint review_report_once unique (reviewID, accID)
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// VoteReview records whether accID found the review helpful. Voting again
+// replaces the earlier vote.
+func (s *PostgresStore) VoteReview(reviewID, accID int, helpful bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`insert into review_vote (reviewID, accID, helpful) values ($1, $2, $3)
+			on conflict (reviewID, accID) do update set helpful = excluded.helpful`,
+		reviewID, accID, helpful)
+	if err != nil {
+		return err
+	}
+	if err := refreshReviewVotes(tx, reviewID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) DeleteReviewVote(reviewID, accID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`delete from review_vote where reviewID = $1 and accID = $2`, reviewID, accID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("no vote on review %d", reviewID)
+	}
+	if err := refreshReviewVotes(tx, reviewID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func refreshReviewVotes(db execer, reviewID int) error {
+	_, err := db.Exec(`update review set
+				helpful_count = (select count(*) from review_vote where reviewID = $1 and helpful),
+				unhelpful_count = (select count(*) from review_vote where reviewID = $1 and not helpful)
+			where id = $1`, reviewID)
+	return err
+}
+
+// SetMerchantReply attaches the shop's public reply to the review. An empty
+// text removes the reply.
+func (s *PostgresStore) SetMerchantReply(reviewID int, text string) error {
+	res, err := s.db.Exec(`update review set
+				merchant_reply = nullif($2, ''),
+				merchant_reply_at = case when $2 = '' then null else now() end
+			where id = $1`, reviewID, text)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("review %d not found", reviewID)
+	}
+	return nil
+}
+
+// CreateReviewReport stores the report and returns how many unresolved
+// reports the review now has.
+func (s *PostgresStore) CreateReviewReport(report *types.ReviewReport) (int, error) {
+	err := s.db.QueryRow(`insert into review_report (reviewID, accID, reason) values ($1, $2, $3)
+			on conflict (reviewID, accID) do nothing returning id, created_at`,
+		report.ReviewID, report.AccID, report.Reason).Scan(&report.ID, &report.CreatedAt)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("review %d already reported", report.ReviewID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	var open int
+	err = s.db.QueryRow(`select count(*) from review_report where reviewID = $1 and not resolved`,
+		report.ReviewID).Scan(&open)
+	return open, err
+}
+
+func (s *PostgresStore) GetReviewReports(resolved bool) ([]*types.ReviewReport, error) {
+	rows, err := s.db.Query(`select id, reviewID, accID, reason, created_at, resolved from review_report
+			where resolved = $1 order by created_at, id`, resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*types.ReviewReport{}
+	for rows.Next() {
+		report := new(types.ReviewReport)
+		if err := rows.Scan(
+			&report.ID,
+			&report.ReviewID,
+			&report.AccID,
+			&report.Reason,
+			&report.CreatedAt,
+			&report.Resolved); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (s *PostgresStore) ResolveReviewReport(id int) error {
+	res, err := s.db.Exec(`update review_report set resolved = true where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("report %d not found", id)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+func (s *PostgresStore) CreateReviewImageTable() error {
+	query := `create table if not exists review_image(
+			id serial primary key,
+			reviewID integer references review(id) on delete cascade,
+			blob_key varchar(200),
+			thumb_key varchar(200),
+			url varchar(500),
+			thumbnail_url varchar(500),
+			status varchar(20) not null default 'pending',
+			created_at timestamp not null default now()
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+const reviewImageSelect = `select id, reviewID, blob_key, thumb_key, url, thumbnail_url, status from review_image`
+
+// CreateReviewImage stores an image of a review that has fewer than max
+// images. The review is locked while counting, so concurrent uploads cannot
+// exceed max together.
+func (s *PostgresStore) CreateReviewImage(img *types.ReviewImage, max int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`select id from review where id = $1 for update`, img.ReviewID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("review %d not found", img.ReviewID)
+	}
+	if err != nil {
+		return err
+	}
+	var n int
+	if err := tx.QueryRow(`select count(*) from review_image where reviewID = $1`, id).Scan(&n); err != nil {
+		return err
+	}
+	if n >= max {
+		return fmt.Errorf("a review can have at most %d images", max)
+	}
+
+	query := `insert into review_image (reviewID, blob_key, thumb_key, url, thumbnail_url, status)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+	err = tx.QueryRow(query,
+		img.ReviewID,
+		img.Key,
+		img.ThumbKey,
+		img.URL,
+		img.ThumbnailURL,
+		img.Status).Scan(&img.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) CountReviewImages(reviewID int) (int, error) {
+	var n int
+	err := s.db.QueryRow(`select count(*) from review_image where reviewID = $1`, reviewID).Scan(&n)
+	return n, err
+}
+
+func (s *PostgresStore) GetReviewImage(id int) (*types.ReviewImage, error) {
+	rows, err := s.db.Query(reviewImageSelect+` where id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		return scanIntoReviewImage(rows)
+	}
+	return nil, fmt.Errorf("image %d not found", id)
+}
+
+// GetReviewImageByKey returns the image whose full size or thumbnail blob
+// is stored under key.
+func (s *PostgresStore) GetReviewImageByKey(key string) (*types.ReviewImage, error) {
+	rows, err := s.db.Query(reviewImageSelect+` where blob_key = $1 or thumb_key = $1`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		return scanIntoReviewImage(rows)
+	}
+	return nil, fmt.Errorf("image %s not found", key)
+}
+
+func (s *PostgresStore) GetReviewImagesByStatus(status types.ReviewStatus) ([]*types.ReviewImage, error) {
+	rows, err := s.db.Query(reviewImageSelect+` where status = $1 order by created_at, id`, status)
+	if err != nil {
+		return nil, err
+	}
+	return collectReviewImages(rows)
+}
+
+func (s *PostgresStore) SetReviewImageStatus(id int, status types.ReviewStatus) error {
+	res, err := s.db.Exec(`update review_image set status = $2 where id = $1`, id, status)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("image %d not found", id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteReviewImage(id int) error {
+	res, err := s.db.Exec(`delete from review_image where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("image %d not found", id)
+	}
+	return nil
+}
+
+// attachReviewImages loads the images of reviews. Public listings only get
+// images a moderator approved.
+func (s *PostgresStore) attachReviewImages(reviews []*types.Review, approvedOnly bool) error {
+	if len(reviews) == 0 {
+		return nil
+	}
+	byID := map[int]*types.Review{}
+	ids := make([]int, 0, len(reviews))
+	for _, review := range reviews {
+		review.Images = []*types.ReviewImage{}
+		byID[review.ID] = review
+		ids = append(ids, review.ID)
+	}
+	rows, err := s.db.Query(reviewImageSelect+` where reviewID = any($1) and (not $2 or status = 'approved')
+			order by id`, pq.Array(ids), approvedOnly)
+	if err != nil {
+		return err
+	}
+	images, err := collectReviewImages(rows)
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		review := byID[img.ReviewID]
+		review.Images = append(review.Images, img)
+	}
+	return nil
+}
+
+func collectReviewImages(rows *sql.Rows) ([]*types.ReviewImage, error) {
+	defer rows.Close()
+	images := []*types.ReviewImage{}
+	for rows.Next() {
+		img, err := scanIntoReviewImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
+
+func scanIntoReviewImage(rows *sql.Rows) (*types.ReviewImage, error) {
+	img := new(types.ReviewImage)
+	err := rows.Scan(
+		&img.ID,
+		&img.ReviewID,
+		&img.Key,
+		&img.ThumbKey,
+		&img.URL,
+		&img.ThumbnailURL,
+		&img.Status)
+	return img, err
+}
@@ -0,0 +1,23 @@
+package storage
+
+import "3legant/types"
+
+// MigrateCartShipping stores the destination and shipping method chosen for
+// a cart.
+func (s *PostgresStore) MigrateCartShipping() error {
+	query := `alter table cart
+			add column if not exists ship_country varchar(2),
+			add column if not exists ship_region varchar(100),
+			add column if not exists ship_postal_code varchar(20),
+			add column if not exists shipping_method varchar(20)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) SetCartShipping(cartID int, dest types.Destination, method string) error {
+	_, err := s.db.Exec(`update cart set ship_country = nullif($2, ''), ship_region = nullif($3, ''),
+				ship_postal_code = nullif($4, ''), shipping_method = nullif($5, ''), updated_at = now()
+			where id = $1`, cartID, dest.Country, dest.Region, dest.PostalCode, method)
+	return err
+}
@@ -1,10 +1,13 @@
 package storage
 
 import (
+	"3legant/money"
 	"3legant/types"
 	"database/sql"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"log"
+	"time"
 )
 
 type Storage interface {
@@ -16,26 +19,130 @@ type Storage interface {
 	UpdateAccount(int, *types.Account) error
 
 	CreateProduct(*types.Product) error
-	DeleteProduct(int) (error, error, error)
+	DeleteProduct(int) error
 	UpdateProduct(int, *types.Product) error
 	GetProducts() ([]*types.Product, error)
 	GetProductByID(int) (*types.Product, error)
 	GetNewProducts() ([]*types.Product, error)
 	SearchProducts(map[string]any) ([]*types.Product, error)
+	RefreshProductRelations() error
+	GetRelatedProducts(int, int, bool) ([]*types.RelatedProduct, error)
 
 	CreateReview(*types.Review) error
 	DeleteReview(int) error
 	UpdateReview(int, *types.Review) error
 	GetReviews() ([]*types.Review, error)
 	GetReviewByID(int) (*types.Review, error)
+	GetProductReviews(int, *types.ReviewQuery) (*types.ReviewPage, error)
+	HasReviewed(int, int) (bool, error)
+	MarkReviewsVerified(int, []int) error
+	SetReviewStatus(int, types.ReviewStatus, string) error
+	GetReviewsByStatus(types.ReviewStatus) ([]*types.Review, error)
+	CountReviewsSince(int, time.Time) (int, error)
+	VoteReview(int, int, bool) error
+	DeleteReviewVote(int, int) error
+	SetMerchantReply(int, string) error
+	CreateReviewReport(*types.ReviewReport) (int, error)
+	GetReviewReports(bool) ([]*types.ReviewReport, error)
+	ResolveReviewReport(int) error
+	CreateReviewImage(*types.ReviewImage, int) error
+	CountReviewImages(int) (int, error)
+	GetReviewImage(int) (*types.ReviewImage, error)
+	GetReviewImageByKey(string) (*types.ReviewImage, error)
+	GetReviewImagesByStatus(types.ReviewStatus) ([]*types.ReviewImage, error)
+	SetReviewImageStatus(int, types.ReviewStatus) error
+	DeleteReviewImage(int) error
 
 	CreateCart(*types.Cart) error
 	UpdateProductQuantityInCart(int, int, int) error
 	DeleteProductFromCart(int, int) error
+	ClearCart(int) error
 	GetCartProductsByUserID(int) ([]*types.ProductCart, error)
 	AddProductToCart(int, int, int) error
+	GetCartByUserID(int) (*types.Cart, error)
+	GetCartProducts(int) ([]*types.ProductCart, error)
+	CreateGuestCart(string) (*types.Cart, error)
+	GetCartByGuestToken(string) (*types.Cart, error)
+	MergeCarts(int, int) error
+	SetCartShipping(int, types.Destination, string) error
+	DeleteExpiredGuestCarts(time.Duration) error
+	GetAbandonedCarts(time.Duration) ([]*types.AbandonedCart, error)
+	MarkCartReminded(int) error
+	GetCartAbandonmentStats(time.Duration) (*types.CartAbandonmentStats, error)
+
+	CreatePromotion(*types.Promotion) error
+	UpdatePromotion(int, *types.Promotion) error
+	DeletePromotion(int) error
+	GetPromotions() ([]*types.Promotion, error)
+	GetPromotionByID(int) (*types.Promotion, error)
+	GetPromotionByCode(string) (*types.Promotion, error)
+	GetAutomaticPromotions() ([]*types.Promotion, error)
+	SetCartCoupon(int, int) error
+	DeleteCartCoupon(int) error
+	GetCartCoupon(int) (*types.Promotion, error)
+	CountRedemptions(int, int) (int, error)
+	GetProductCategories([]int) (map[int][]string, error)
+
+	PlaceOrder(*types.Order, int) error
+	GetOrderByID(int) (*types.Order, error)
+	GetOrders(*types.OrderQuery) ([]*types.Order, error)
+	TransitionOrder(int, types.OrderStatus, types.OrderStatus, int, string) error
+	CreatePayment(*types.Payment) error
+	UpdatePaymentStatus(int, string, string, string) error
+	AddPaymentRefund(int, money.Amount) error
+	GetPaymentByIntent(string) (*types.Payment, error)
+	GetOrderPayments(int) ([]*types.Payment, error)
+	GetOrdersAwaitingRelease(time.Duration) ([]*types.Order, error)
+	CreateReturn(*types.Return) error
+	ApproveReturn(int, string) error
+	RejectReturn(int, string) error
+	ReceiveReturn(int) error
+	RefundReturn(int, money.Amount) error
+	RevertReturnRefund(int, money.Amount) error
+	GetReturnByID(int) (*types.Return, error)
+	GetReturns(types.ReturnStatus) ([]*types.Return, error)
+	GetOrderReturns(int) ([]*types.Return, error)
 
 	GetCategories() ([]*types.Category, error)
+
+	CreateWishlist(*types.Wishlist) error
+	GetWishlistsByAccountID(int) ([]*types.Wishlist, error)
+	GetWishlist(int, int) (*types.Wishlist, error)
+	GetWishlistByShareToken(string) (*types.Wishlist, error)
+	UpdateWishlist(int, int, string) error
+	DeleteWishlist(int, int) error
+	SetWishlistShareToken(int, int, string) error
+	AddProductToWishlist(int, int, int) error
+	DeleteProductFromWishlist(int, int, int) error
+	MoveCartProductToWishlist(int, int, int) error
+	MoveWishlistProductToCart(int, int, int, int) error
+
+	CreateAddress(*types.Address) error
+	UpdateAddress(*types.Address) error
+	DeleteAddress(int, int) error
+	GetAddresses(int) ([]*types.Address, error)
+	GetAddress(int, int) (*types.Address, error)
+	GetDefaultAddress(int, types.AddressKind) (*types.Address, error)
+
+	CreateInvoice(int) (*types.Invoice, error)
+	GetOrderInvoice(int) (*types.Invoice, error)
+
+	ClaimIdempotencyKey(*types.IdempotencyRecord, time.Duration) (*types.IdempotencyRecord, error)
+	CompleteIdempotencyKey(*types.IdempotencyRecord) error
+	ReleaseIdempotencyKey(string, string) error
+	DeleteExpiredIdempotencyKeys() error
+
+	DispatchEvents(int) (int, error)
+	ClaimDueDeliveries(int, time.Duration) ([]*types.WebhookDelivery, error)
+	RecordDeliveryAttempt(int, *types.DeliveryAttempt) error
+	ReplayDelivery(int) (*types.WebhookDelivery, error)
+	GetWebhookDelivery(int) (*types.WebhookDelivery, error)
+	GetWebhookDeliveries(int, types.DeliveryStatus) ([]*types.WebhookDelivery, error)
+	CreateWebhookSubscription(*types.WebhookSubscription) error
+	UpdateWebhookSubscription(*types.WebhookSubscription) error
+	DeleteWebhookSubscription(int) error
+	GetWebhookSubscriptions() ([]*types.WebhookSubscription, error)
+	GetWebhookSubscription(int) (*types.WebhookSubscription, error)
 }
 
 type PostgresStore struct {
@@ -61,12 +168,47 @@ func (s *PostgresStore) Init() []error {
 	var errors []error
 	errors = append(errors, s.CreateAccountTable())
 	errors = append(errors, s.CreateProductTable())
+	errors = append(errors, s.MigrateProductTable())
 	errors = append(errors, s.CreateReviewTable())
+	errors = append(errors, s.MigrateReviewTable())
 	errors = append(errors, s.CreateCategoryTable())
 	errors = append(errors, s.CreateProductCategoryTable())
-	errors = append(errors, s.CreateProductReviewTable())
+	errors = append(errors, s.DropProductReviewTable())
 	errors = append(errors, s.CreateCartTable())
+	errors = append(errors, s.MigrateCartTable())
+	errors = append(errors, s.MigrateCartActivity())
+	errors = append(errors, s.MigrateCartShipping())
 	errors = append(errors, s.CreateCartProductTable())
+	errors = append(errors, s.MigrateCartProductTable())
+	errors = append(errors, s.CreateProductRelationTable())
+	errors = append(errors, s.CreateWishlistTable())
+	errors = append(errors, s.CreateWishlistProductTable())
+	errors = append(errors, s.CreateAddressTable())
+	errors = append(errors, s.CreateProductRatingTable())
+	errors = append(errors, s.CreateReviewAccountIndex())
+	errors = append(errors, s.CreateReviewVoteTable())
+	errors = append(errors, s.CreateReviewReportTable())
+	errors = append(errors, s.CreateReviewImageTable())
+	errors = append(errors, s.CreatePromotionTable())
+	errors = append(errors, s.CreatePromotionRedemptionTable())
+	errors = append(errors, s.CreateCartCouponTable())
+	errors = append(errors, s.CreateOrderTable())
+	errors = append(errors, s.MigrateOrderTable())
+	errors = append(errors, s.CreateOrderLineTable())
+	errors = append(errors, s.MigrateOrderLineTable())
+	errors = append(errors, s.CreateOrderAdjustmentTable())
+	errors = append(errors, s.CreateOrderTaxTable())
+	errors = append(errors, s.CreateOrderEventTable())
+	errors = append(errors, s.CreateOrderAddressTable())
+	errors = append(errors, s.CreatePaymentTable())
+	errors = append(errors, s.CreateInvoiceTable())
+	errors = append(errors, s.CreateReturnTable())
+	errors = append(errors, s.CreateReturnLineTable())
+	errors = append(errors, s.CreateIdempotencyKeyTable())
+	errors = append(errors, s.CreateOutboxTable())
+	errors = append(errors, s.CreateWebhookSubscriptionTable())
+	errors = append(errors, s.CreateWebhookDeliveryTable())
+	errors = append(errors, s.RefreshProductRatings())
 	return errors
 }
 
@@ -85,10 +227,16 @@ func (s *PostgresStore) CreateAccountTable() error {
 }
 
 func (s *PostgresStore) CreateAccount(acc *types.Account) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	query := `insert into account (first_name, last_name, e_mail, encrypted_password, user_type)
 								   values ($1, $2, $3, $4, $5) returning id`
 	var id int
-	err := s.db.QueryRow(query,
+	err = tx.QueryRow(query,
 		acc.FirstName,
 		acc.LastName,
 		acc.Email,
@@ -99,8 +247,12 @@ func (s *PostgresStore) CreateAccount(acc *types.Account) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	acc.ID = id
+	if err := insertEvent(tx, types.EventAccountCreated, acc); err != nil {
+		return 0, err
+	}
 
-	return id, nil
+	return id, tx.Commit()
 }
 
 func (s *PostgresStore) GetAccountByEmail(email string) (*types.Account, error) {
@@ -195,52 +347,140 @@ func (s *PostgresStore) CreateProductTable() error {
 	return err
 }
 
+// MigrateProductTable adds the columns introduced after the product table was
+// first created. A null stock means the product's stock is not tracked.
+// Archived products are no longer sold but stay referenced by carts.
+// tax_class picks the tax rate the product is charged at.
+func (s *PostgresStore) MigrateProductTable() error {
+	query := `alter table product
+			add column if not exists stock integer,
+			add column if not exists weight numeric(8, 2),
+			add column if not exists tax_class varchar(20) not null default 'standard',
+			add column if not exists archived boolean not null default false`
+
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+	// prices used to be stored as real, which cannot hold every cent value.
+	// Converting rewrites the table, so it is only done once.
+	var dataType string
+	err := s.db.QueryRow(`select data_type from information_schema.columns
+			where table_schema = current_schema() and table_name = 'product' and column_name = 'price'`).Scan(&dataType)
+	if err != nil || dataType != "real" {
+		return err
+	}
+	_, err = s.db.Exec(`alter table product alter column price type numeric(12, 2) using round(price::numeric, 2)`)
+	return err
+}
+
 func (s *PostgresStore) CreateProduct(product *types.Product) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
 	query := `insert into product
-    		(name, price, measurements, description, packaging)
-								   values ($1, $2, $3, $4, $5)`
-	resp, err := s.db.Query(query,
+    		(name, price, measurements, description, packaging, stock, weight, tax_class)
+								   values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err = tx.QueryRow(query,
 		product.Name,
 		product.Price,
 		product.Measurements,
 		product.Description,
-		product.Packaging)
-
+		product.Packaging,
+		product.Stock,
+		product.Weight,
+		product.TaxClass).Scan(&product.ID)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%+v\n", resp)
-	return nil
+	if err := insertEvent(tx, types.EventProductCreated, product); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+const productSelect = `select p.id, p.name, p.price, p.measurements, p.description, p.packaging, p.stock, p.weight, p.tax_class, p.archived,
+			coalesce(r.review_count, 0), coalesce(r.average_rating, 0),
+			coalesce(r.star1, 0), coalesce(r.star2, 0), coalesce(r.star3, 0), coalesce(r.star4, 0), coalesce(r.star5, 0)
+		from product p left join product_rating r on r.prodID = p.id`
+
 func scanIntoProduct(rows *sql.Rows) (*types.Product, error) {
 	product := new(types.Product)
+	var stars [5]int
+	var stock sql.NullInt64
+	var weight sql.NullFloat64
 	err := rows.Scan(
 		&product.ID,
 		&product.Name,
 		&product.Price,
 		&product.Measurements,
 		&product.Description,
-		&product.Packaging)
+		&product.Packaging,
+		&stock,
+		&weight,
+		&product.TaxClass,
+		&product.Archived,
+		&product.ReviewCount,
+		&product.AverageRating,
+		&stars[0],
+		&stars[1],
+		&stars[2],
+		&stars[3],
+		&stars[4])
+	if stock.Valid {
+		n := int(stock.Int64)
+		product.Stock = &n
+	}
+	if weight.Valid {
+		product.Weight = &weight.Float64
+	}
+	product.RatingDistribution = map[int]int{}
+	for i, n := range stars {
+		product.RatingDistribution[i+1] = n
+	}
 	return product, err
 }
 
 func (s *PostgresStore) UpdateProduct(id int, product *types.Product) error {
-	_, err := s.db.Query(`update product set name=$2, price=$3, measurements=$4, description=$5, packaging=$6 WHERE id=$1`,
-		id, product.Name, product.Price, product.Measurements, product.Description, product.Packaging)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`update product set name=$2, price=$3, measurements=$4, description=$5, packaging=$6, stock=$7, weight=$8, tax_class=$9 WHERE id=$1`,
+		id, product.Name, product.Price, product.Measurements, product.Description, product.Packaging, product.Stock, product.Weight,
+		product.TaxClass)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("product %d not found", id)
+	}
+	product.ID = id
+	if err := insertEvent(tx, types.EventProductUpdated, product); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *PostgresStore) DeleteProduct(id int) (error, error, error) {
-	_, err1 := s.db.Query(`delete from product_review where prodid = $1`, id)
-	_, err2 := s.db.Query(`delete from product_category where prodid = $1`, id)
-	_, err3 := s.db.Query(`delete from product where id = $1`, id)
-	return err1, err2, err3
+// DeleteProduct archives the product. It disappears from the catalogue but
+// carts that hold it keep a reference and can tell the customer.
+func (s *PostgresStore) DeleteProduct(id int) error {
+	res, err := s.db.Exec(`update product set archived = true where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("product %d not found", id)
+	}
+	return nil
 }
 
 func (s *PostgresStore) GetProductByID(id int) (*types.Product, error) {
-	rows, err := s.db.Query(`select * from product where id = $1`, id)
+	rows, err := s.db.Query(productSelect+` where p.id = $1`, id)
 	if err != nil {
 		return nil, err
 	}
@@ -251,7 +491,7 @@ func (s *PostgresStore) GetProductByID(id int) (*types.Product, error) {
 }
 
 func (s *PostgresStore) GetProducts() ([]*types.Product, error) {
-	rows, err := s.db.Query(`select * from product`)
+	rows, err := s.db.Query(productSelect + ` where not p.archived`)
 	if err != nil {
 		return nil, err
 	}
@@ -269,7 +509,7 @@ func (s *PostgresStore) GetProducts() ([]*types.Product, error) {
 }
 
 func (s *PostgresStore) GetNewProducts() ([]*types.Product, error) {
-	rows, err := s.db.Query(`select * from product order by id desc limit 5`)
+	rows, err := s.db.Query(productSelect + ` where not p.archived order by p.id desc limit 5`)
 	if err != nil {
 		return nil, err
 	}
@@ -291,14 +531,24 @@ func (s *PostgresStore) SearchProducts(params map[string]any) ([]*types.Product,
 	priceTo := params["priceTo"]
 	skip := params["skip"]
 	limit := params["limit"]
+	minRating := params["minRating"]
 	if skip == "" {
 		skip = "1"
 	}
 	if limit == "" {
 		limit = "2147483647"
 	}
-	rows, err := s.db.Query(`select * from product where lower(name) like lower($1) and  price >= $2 and price <=$3 order by id offset $4 - 1 limit $5 - $4 + 1 `,
-		name, priceFrom, priceTo, skip, limit)
+	if minRating == nil || minRating == "" {
+		minRating = "0"
+	}
+	sort, _ := params["sort"].(string)
+	orderBy, ok := productOrderBy[sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort %s", sort)
+	}
+	rows, err := s.db.Query(productSelect+` where not p.archived and lower(p.name) like lower($1) and  p.price >= $2 and p.price <=$3
+			and coalesce(r.average_rating, 0) >= $6 order by `+orderBy+` offset $4 - 1 limit $5 - $4 + 1 `,
+		name, priceFrom, priceTo, skip, limit, minRating)
 	if err != nil {
 		return nil, err
 	}
@@ -313,6 +563,15 @@ func (s *PostgresStore) SearchProducts(params map[string]any) ([]*types.Product,
 	return products, nil
 }
 
+// productOrderBy maps the sort values accepted by SearchProducts to order by
+// clauses.
+var productOrderBy = map[string]string{
+	"":            "p.id",
+	"rating_desc": "coalesce(r.average_rating, 0) desc, coalesce(r.review_count, 0) desc, p.id",
+	"rating_asc":  "coalesce(r.average_rating, 0), p.id",
+	"reviews":     "coalesce(r.review_count, 0) desc, p.id",
+}
+
 // REVIEW
 
 func (s *PostgresStore) CreateReviewTable() error {
@@ -328,47 +587,228 @@ func (s *PostgresStore) CreateReviewTable() error {
 	return err
 }
 
+// MigrateReviewTable adds the columns introduced after the review table was
+// first created.
+func (s *PostgresStore) MigrateReviewTable() error {
+	query := `alter table review
+			add column if not exists created_at timestamp not null default now(),
+			add column if not exists helpful_count integer not null default 0,
+			add column if not exists verified boolean not null default false,
+			add column if not exists status varchar(20) not null default 'approved',
+			add column if not exists moderation_reason varchar(200) not null default '',
+			add column if not exists unhelpful_count integer not null default 0,
+			add column if not exists merchant_reply varchar(1000),
+			add column if not exists merchant_reply_at timestamp`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CreateReviewAccountIndex lets every account review a product only once.
+// It runs once, while the index does not exist yet: reviews written before
+// that was enforced are archived with the duplicate status rather than
+// deleted, keeping the newest review of each account and product, and every
+// archived review is logged so moderators can look at them.
+func (s *PostgresStore) CreateReviewAccountIndex() error {
+	var exists bool
+	err := s.db.QueryRow(`select exists(select 1 from pg_indexes
+			where schemaname = current_schema() and indexname = 'review_acc_prod_idx')`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`update review r set status = $1
+			where r.status <> $1 and exists(select 1 from review newer
+				where newer.accID = r.accID and newer.prodID = r.prodID
+					and (newer.created_at, newer.id) > (r.created_at, r.id))
+			returning r.id, r.accID, r.prodID`, types.ReviewStatusDuplicate)
+	if err != nil {
+		return err
+	}
+	prodIDs := map[int]bool{}
+	for rows.Next() {
+		var id, accID, prodID int
+		if err := rows.Scan(&id, &accID, &prodID); err != nil {
+			rows.Close()
+			return err
+		}
+		log.Printf("review %d of account %d for product %d archived as a duplicate", id, accID, prodID)
+		prodIDs[prodID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for prodID := range prodIDs {
+		if err := refreshProductRating(tx, prodID); err != nil {
+			return err
+		}
+	}
+	// index predicates cannot take parameters
+	_, err = tx.Exec(`create unique index review_acc_prod_idx on review (accID, prodID)
+			where status <> '` + string(types.ReviewStatusDuplicate) + `'`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const reviewSelect = `select id, accID, prodID, rating_given, text, created_at, helpful_count, verified,
+			status, moderation_reason, unhelpful_count, merchant_reply, merchant_reply_at from review`
+
 func (s *PostgresStore) CreateReview(rev *types.Review) error {
-	query := `insert into review (accID, prodID, rating_given, text)
-								   values ($1, $2, $3, $4)`
-	resp, err := s.db.Query(query,
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// reviews of products the author already received count as verified
+	query := `insert into review (accID, prodID, rating_given, text, status, moderation_reason, verified)
+								   values ($1, $2, $3, $4, $5, $6, exists(
+									select 1 from orders o join order_line l on l.orderID = o.id
+									where o.accID = $1 and l.prodID = $2 and o.status = 'delivered'))
+								   returning id, created_at, verified`
+	err = tx.QueryRow(query,
 		rev.AccID,
 		rev.ProdID,
 		rev.RatingGiven,
-		rev.Text)
-
+		rev.Text,
+		rev.Status,
+		rev.ModerationReason).Scan(&rev.ID, &rev.CreatedAt, &rev.Verified)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%+v\n", resp)
-	return nil
+	if err := refreshProductRating(tx, rev.ProdID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *PostgresStore) UpdateReview(id int, review *types.Review) error {
-	_, err := s.db.Query(`UPDATE review SET rating_given=$2, text=$3 WHERE id=$1`,
-		id, review.RatingGiven, review.Text)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prodID int
+	// archived duplicates stay archived
+	err = tx.QueryRow(`UPDATE review SET rating_given=$2, text=$3, status=$4, moderation_reason=$5 WHERE id=$1 and status <> $6 returning prodID`,
+		id, review.RatingGiven, review.Text, review.Status, review.ModerationReason, types.ReviewStatusDuplicate).Scan(&prodID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("review %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	if err := refreshProductRating(tx, prodID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetReviewStatus records a moderation decision on the review.
+func (s *PostgresStore) SetReviewStatus(id int, status types.ReviewStatus, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prodID int
+	err = tx.QueryRow(`update review set status = $2, moderation_reason = $3 where id = $1 and status <> $4 returning prodID`,
+		id, status, reason, types.ReviewStatusDuplicate).Scan(&prodID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("review %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	if err := refreshProductRating(tx, prodID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *PostgresStore) DeleteReview(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	//_, err1 := s.db.Query(`delete from product_review where revewid = $1`, id)
-	_, err := s.db.Query(`delete from review where id = $1`, id)
-	return err
+	var prodID int
+	err = tx.QueryRow(`delete from review where id = $1 returning prodID`, id).Scan(&prodID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("review %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	if err := refreshProductRating(tx, prodID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *PostgresStore) GetReviewByID(id int) (*types.Review, error) {
-	rows, err := s.db.Query(`select * from review where id = $1`, id)
+	rows, err := s.db.Query(reviewSelect+` where id = $1`, id)
 	if err != nil {
 		return nil, err
 	}
 	for rows.Next() {
-		return scanIntoReview(rows)
+		review, err := scanIntoReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		rows.Close()
+		if err := s.attachReviewImages([]*types.Review{review}, false); err != nil {
+			return nil, err
+		}
+		return review, nil
 	}
 	return nil, fmt.Errorf("review %d not found", id)
 }
 
+func (s *PostgresStore) HasReviewed(accID, prodID int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`select exists(select 1 from review where accID = $1 and prodID = $2)`,
+		accID, prodID).Scan(&exists)
+	return exists, err
+}
+
+// MarkReviewsVerified flags the reviews accID wrote for any of prodIDs as
+// verified purchases.
+func (s *PostgresStore) MarkReviewsVerified(accID int, prodIDs []int) error {
+	_, err := s.db.Exec(`update review set verified = true where accID = $1 and prodID = any($2)`,
+		accID, pq.Array(prodIDs))
+	return err
+}
+
+func (s *PostgresStore) CountReviewsSince(accID int, since time.Time) (int, error) {
+	var n int
+	err := s.db.QueryRow(`select count(*) from review where accID = $1 and created_at >= $2`, accID, since).Scan(&n)
+	return n, err
+}
+
 func (s *PostgresStore) GetReviews() ([]*types.Review, error) {
-	rows, err := s.db.Query(`select * from review`)
+	return s.getReviewsByStatus(types.ReviewStatusApproved, true)
+}
+
+func (s *PostgresStore) GetReviewsByStatus(status types.ReviewStatus) ([]*types.Review, error) {
+	return s.getReviewsByStatus(status, false)
+}
+
+func (s *PostgresStore) getReviewsByStatus(status types.ReviewStatus, approvedImagesOnly bool) ([]*types.Review, error) {
+	rows, err := s.db.Query(reviewSelect+` where status = $1 order by created_at, id`, status)
 	if err != nil {
 		return nil, err
 	}
@@ -380,20 +820,84 @@ func (s *PostgresStore) GetReviews() ([]*types.Review, error) {
 		}
 		reviews = append(reviews, review)
 	}
+	rows.Close()
+	if err := s.attachReviewImages(reviews, approvedImagesOnly); err != nil {
+		return nil, err
+	}
 	return reviews, nil
 }
 
 func scanIntoReview(rows *sql.Rows) (*types.Review, error) {
 	review := new(types.Review)
+	var reply sql.NullString
+	var replyAt sql.NullTime
 	err := rows.Scan(
 		&review.ID,
 		&review.AccID,
 		&review.ProdID,
 		&review.RatingGiven,
-		&review.Text)
+		&review.Text,
+		&review.CreatedAt,
+		&review.HelpfulCount,
+		&review.Verified,
+		&review.Status,
+		&review.ModerationReason,
+		&review.UnhelpfulCount,
+		&reply,
+		&replyAt)
+	if reply.Valid {
+		review.MerchantReply = &types.ReviewReply{Text: reply.String, CreatedAt: replyAt.Time}
+	}
 	return review, err
 }
 
+// reviewOrderBy maps the sort values accepted by GetProductReviews to order
+// by clauses.
+var reviewOrderBy = map[string]string{
+	"":        "created_at desc, id desc",
+	"newest":  "created_at desc, id desc",
+	"highest": "rating_given desc, created_at desc, id desc",
+	"lowest":  "rating_given, created_at desc, id desc",
+	"helpful": "helpful_count desc, unhelpful_count, created_at desc, id desc",
+}
+
+func (s *PostgresStore) GetProductReviews(prodID int, q *types.ReviewQuery) (*types.ReviewPage, error) {
+	orderBy, ok := reviewOrderBy[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort %s", q.Sort)
+	}
+	where := ` where prodID = $1 and status = 'approved' and ($2 = 0 or least(greatest(round(rating_given), 1), 5) = $2)
+			and ($3::boolean is null or verified = $3)`
+	args := []any{prodID, q.Rating, q.Verified}
+
+	page := &types.ReviewPage{Page: q.Page, PageSize: q.PageSize, Reviews: []*types.Review{}}
+	if err := s.db.QueryRow(`select count(*) from review`+where, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(reviewSelect+where+` order by `+orderBy+` offset $4 limit $5`,
+		append(args, (q.Page-1)*q.PageSize, q.PageSize)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		review, err := scanIntoReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Reviews = append(page.Reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := s.attachReviewImages(page.Reviews, true); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
 // CATEGORY
 
 func (s *PostgresStore) GetCategories() ([]*types.Category, error) {
@@ -434,41 +938,129 @@ func (s *PostgresStore) CreateCart(cart *types.Cart) error {
 	return nil
 }
 
-func (s *PostgresStore) AddProductToCart(userID, prodID, quantity int) error {
-	cart, err := s.getCartByUserID(userID)
-	cartID := cart.CartID
+// AddProductToCart adds quantity of the product to the cart, on top of what
+// the cart already holds, as long as there is enough stock.
+func (s *PostgresStore) AddProductToCart(cartID, prodID, quantity int) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	query := `insert into cart_product (cart_id, product_id, quantity)
-								   values ($1, $2, $3)`
-	resp, err := s.db.Query(query,
-		cartID,
-		prodID,
-		quantity,
-	)
+	defer tx.Rollback()
 
+	product, err := lockProduct(tx, prodID)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%+v\n", resp)
-	return nil
-
+	stock := product.stock
+	// adding more of a product keeps the price it was first added at, so a
+	// price change is still pointed out at checkout
+	var total int
+	err = tx.QueryRow(`insert into cart_product (cart_id, product_id, quantity, unit_price) values ($1, $2, $3, $4)
+			on conflict (cart_id, product_id) do update
+			set quantity = cart_product.quantity + excluded.quantity,
+				unit_price = coalesce(cart_product.unit_price, excluded.unit_price)
+			returning quantity`,
+		cartID, prodID, quantity, product.price).Scan(&total)
+	if err != nil {
+		return err
+	}
+	if stock.Valid && int64(total) > stock.Int64 {
+		return fmt.Errorf("only %d of product %d in stock", stock.Int64, prodID)
+	}
+	if err := touchCart(tx, cartID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *PostgresStore) UpdateProductQuantityInCart(cartID, prodID, quantity int) error {
-	_, err := s.db.Query(`UPDATE cart_product SET quantity=$3 WHERE cart_id=$1 and product_id=$2 `,
-		cartID, prodID, quantity)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	product, err := lockProduct(tx, prodID)
+	if err != nil {
+		return err
+	}
+	stock := product.stock
+	if stock.Valid && int64(quantity) > stock.Int64 {
+		return fmt.Errorf("only %d of product %d in stock", stock.Int64, prodID)
+	}
+	// the price the product was added at stays until the customer accepts
+	// the new one at checkout
+	res, err := tx.Exec(`update cart_product set quantity = $3, unit_price = coalesce(unit_price, $4)
+			where cart_id = $1 and product_id = $2`,
+		cartID, prodID, quantity, product.price)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("product %d not in cart", prodID)
+	}
+	if err := touchCart(tx, cartID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type lockedProduct struct {
+	price money.Amount
+	// stock is NULL if it is not tracked
+	stock sql.NullInt64
+}
+
+// lockProduct returns the price and stock of a product that can be put in a
+// cart and locks its row until the transaction ends.
+func lockProduct(tx *sql.Tx, prodID int) (*lockedProduct, error) {
+	product := new(lockedProduct)
+	var price float64
+	var archived bool
+	err := tx.QueryRow(`select price, stock, archived from product where id = $1 for update`, prodID).
+		Scan(&price, &product.stock, &archived)
+	if err == sql.ErrNoRows || archived {
+		return nil, fmt.Errorf("product %d not found", prodID)
+	}
+	product.price = money.FromFloat(price)
+	return product, err
 }
 
 func (s *PostgresStore) DeleteProductFromCart(cartID, productID int) error {
-	_, err := s.db.Query(`delete from cart_product where cart_id = $1 and product_id = $2`, cartID, productID)
-	return err
+	res, err := s.db.Exec(`delete from cart_product where cart_id = $1 and product_id = $2`, cartID, productID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("product %d not in cart", productID)
+	}
+	return touchCart(s.db, cartID)
+}
+
+func (s *PostgresStore) ClearCart(cartID int) error {
+	if _, err := s.db.Exec(`delete from cart_product where cart_id = $1`, cartID); err != nil {
+		return err
+	}
+	return touchCart(s.db, cartID)
+}
+
+// GetCartByUserID returns the account's cart, creating it if the account has
+// none yet.
+func (s *PostgresStore) GetCartByUserID(userID int) (*types.Cart, error) {
+	cart, err := s.getCartByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if cart.CartID != 0 {
+		return cart, nil
+	}
+	cart = types.NewCart(userID)
+	err = s.db.QueryRow(`insert into cart (user_id) values ($1) returning id`, userID).Scan(&cart.CartID)
+	return cart, err
 }
 
 func (s *PostgresStore) getCartByUserID(userID int) (*types.Cart, error) {
-	rows, err := s.db.Query(`select * from cart where user_id = $1`, userID)
+	rows, err := s.db.Query(cartSelect+` where user_id = $1`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -484,16 +1076,27 @@ func (s *PostgresStore) getCartByUserID(userID int) (*types.Cart, error) {
 
 func (s *PostgresStore) GetCartProductsByUserID(userID int) ([]*types.ProductCart, error) {
 	cart, err := s.getCartByUserID(userID)
-	var products []*types.ProductCart
 	if err != nil {
 		return nil, err
 	}
+	products, err := s.GetCartProducts(cart.CartID)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) != 0 {
+		return products, nil
+	}
+	return nil,  fmt.Errorf("%v not found", userID)
+}
 
-	rows, err := s.db.Query(`select * from cart_product where cart_id = $1`, cart.CartID)
+func (s *PostgresStore) GetCartProducts(cartID int) ([]*types.ProductCart, error) {
+	rows, err := s.db.Query(`select cart_id, product_id, quantity, unit_price from cart_product where cart_id = $1 order by product_id`, cartID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
+	products := []*types.ProductCart{}
 	for rows.Next() {
 		product, err := s.scanIntoCartProduct(rows)
 		if err != nil {
@@ -501,18 +1104,23 @@ func (s *PostgresStore) GetCartProductsByUserID(userID int) ([]*types.ProductCar
 		}
 		products = append(products, product)
 	}
-	if products != nil {
-		return products, nil
-	}
-	return nil,  fmt.Errorf("%v not found", userID)
+	return products, rows.Err()
 }
 
 
+const cartSelect = `select id, coalesce(user_id, 0), coalesce(ship_country, ''), coalesce(ship_region, ''),
+			coalesce(ship_postal_code, ''), coalesce(shipping_method, '')
+		from cart`
+
 func scanIntoCart(rows *sql.Rows) (*types.Cart, error) {
 	cart := new(types.Cart)
 	err := rows.Scan(
 		&cart.CartID,
 		&cart.UserID,
+		&cart.Destination.Country,
+		&cart.Destination.Region,
+		&cart.Destination.PostalCode,
+		&cart.ShippingMethod,
 	)
 	return cart, err
 }
@@ -521,14 +1129,20 @@ func (s *PostgresStore) scanIntoCartProduct(rows *sql.Rows) (*types.ProductCart,
 	prodCart := new(types.ProductCart)
 	//prod := new(*Product)
 	//prod, err := s.GetProductByID(prodID)
+	var unitPrice sql.NullInt64
 	err := rows.Scan(
 		&prodCart.CartID,
 		&prodCart.ProdID,
 		&prodCart.Quantity,
+		&unitPrice,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if unitPrice.Valid {
+		price := money.Amount(unitPrice.Int64)
+		prodCart.UnitPrice = &price
+	}
 	return prodCart, nil
 }
 
@@ -554,14 +1168,11 @@ func (s *PostgresStore) CreateCategoryTable() error {
 	return err
 }
 
-func (s *PostgresStore) CreateProductReviewTable() error {
-	query := `create table if not exists product_review( 
-			prodID serial references product(id),
-			reviewID serial references review(id),
-			constraint product_review_pk primary key (prodID, reviewID)
-		)`
-
-	_, err := s.db.Exec(query)
+// DropProductReviewTable removes the old product_review join table. It was
+// never written to; review.prodID is the only link between reviews and
+// products.
+func (s *PostgresStore) DropProductReviewTable() error {
+	_, err := s.db.Exec(`drop table if exists product_review`)
 	return err
 }
 
@@ -575,6 +1186,27 @@ func (s *PostgresStore) CreateCartTable() error {
 	return err
 }
 
+// MigrateCartProductTable remembers the price of a product when it was put
+// in the cart so later price changes can be pointed out. It is kept in
+// cents like every other amount.
+func (s *PostgresStore) MigrateCartProductTable() error {
+	query := `alter table cart_product
+			add column if not exists unit_price bigint`
+
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+	// unit prices used to be stored as numeric(12, 2)
+	var dataType string
+	err := s.db.QueryRow(`select data_type from information_schema.columns
+			where table_schema = current_schema() and table_name = 'cart_product' and column_name = 'unit_price'`).Scan(&dataType)
+	if err != nil || dataType != "numeric" {
+		return err
+	}
+	_, err = s.db.Exec(`alter table cart_product alter column unit_price type bigint using round(unit_price * 100)`)
+	return err
+}
+
 func (s *PostgresStore) CreateCartProductTable() error {
 	query := `create table if not exists cart_product( 
 			cart_id serial references cart(id),
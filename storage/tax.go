@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+)
+
+// CreateOrderTaxTable holds the tax breakdown of an order as it was when the
+// order was placed.
+func (s *PostgresStore) CreateOrderTaxTable() error {
+	query := `create table if not exists order_tax(
+			id serial primary key,
+			orderID integer references orders(id) on delete cascade,
+			name varchar(100) not null,
+			rate_bp integer not null,
+			taxable bigint not null,
+			amount bigint not null
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func insertOrderTaxes(tx *sql.Tx, order *types.Order) error {
+	for _, t := range order.Taxes {
+		_, err := tx.Exec(`insert into order_tax (orderID, name, rate_bp, taxable, amount)
+				values ($1, $2, $3, $4, $5)`,
+			order.ID, t.Name, t.RateBP, t.Taxable, t.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStore) attachOrderTaxes(order *types.Order) error {
+	rows, err := s.db.Query(`select name, rate_bp, taxable, amount
+			from order_tax where orderID = $1 order by id`, order.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	order.Taxes = []*types.TaxLine{}
+	for rows.Next() {
+		t := new(types.TaxLine)
+		if err := rows.Scan(&t.Name, &t.RateBP, &t.Taxable, &t.Amount); err != nil {
+			return err
+		}
+		order.Taxes = append(order.Taxes, t)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// CreateOutboxTable holds the events waiting to be handed to webhooks.
+// Events are inserted in the transaction of the change they describe, so a
+// crash can neither lose an event nor emit one for a change that was rolled
+// back.
+func (s *PostgresStore) CreateOutboxTable() error {
+	query := `create table if not exists outbox_event(
+			id serial primary key,
+			type varchar(50) not null,
+			data jsonb not null,
+			created_at timestamp not null default now(),
+			dispatched_at timestamp
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateWebhookSubscriptionTable() error {
+	query := `create table if not exists webhook_subscription(
+			id serial primary key,
+			url varchar(500) not null,
+			events text[] not null,
+			secret varchar(100) not null,
+			active boolean not null default true,
+			created_at timestamp not null default now()
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateWebhookDeliveryTable() error {
+	query := `create table if not exists webhook_delivery(
+			id serial primary key,
+			subscriptionID integer references webhook_subscription(id) on delete cascade,
+			eventID integer references outbox_event(id),
+			status varchar(20) not null,
+			attempts integer not null default 0,
+			next_attempt_at timestamp,
+			last_status_code integer,
+			last_error varchar(500),
+			created_at timestamp not null default now(),
+			delivered_at timestamp
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// insertEvent writes an event to the outbox. Pass the transaction of the
+// change the event is about.
+func insertEvent(db execer, eventType types.EventType, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	// passed as a string, pq would send []byte as bytea
+	_, err = db.Exec(`insert into outbox_event (type, data) values ($1, $2)`, eventType, string(payload))
+	return err
+}
+
+// DispatchEvents turns up to limit outbox events into one pending delivery
+// per subscription that wants them. It returns how many events it took.
+func (s *PostgresStore) DispatchEvents(limit int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`select id, type from outbox_event where dispatched_at is null
+			order by id limit $1 for update skip locked`, limit)
+	if err != nil {
+		return 0, err
+	}
+	type event struct {
+		id        int
+		eventType string
+	}
+	events := []event{}
+	for rows.Next() {
+		var e event
+		if err := rows.Scan(&e.id, &e.eventType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, e := range events {
+		_, err := tx.Exec(`insert into webhook_delivery (subscriptionID, eventID, status, next_attempt_at)
+				select id, $1, $2, now() from webhook_subscription
+				where active and ($3 = any(events) or $4 = any(events))`,
+			e.id, types.DeliveryPending, e.eventType, types.EventAll)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`update outbox_event set dispatched_at = now() where id = $1`, e.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(events), tx.Commit()
+}
+
+// ClaimDueDeliveries returns up to limit pending deliveries whose next
+// attempt is due. They are leased for lease, so concurrent workers do not
+// send them twice and a crashed worker's deliveries are retried later.
+func (s *PostgresStore) ClaimDueDeliveries(limit int, lease time.Duration) ([]*types.WebhookDelivery, error) {
+	return s.queryDeliveries(`with due as (
+				update webhook_delivery set next_attempt_at = now() + make_interval(secs => $3)
+				where id in (
+					select d.id from webhook_delivery d
+					join webhook_subscription ws on ws.id = d.subscriptionID
+					where d.status = $1 and d.next_attempt_at <= now() and ws.active
+					order by d.next_attempt_at limit $2 for update of d skip locked)
+				returning *)
+			`+deliverySelect(`due`)+` order by d.id`,
+		types.DeliveryPending, limit, int(lease.Seconds()))
+}
+
+// RecordDeliveryAttempt stores the outcome of sending a delivery.
+func (s *PostgresStore) RecordDeliveryAttempt(id int, attempt *types.DeliveryAttempt) error {
+	status := types.DeliveryPending
+	if attempt.Succeeded {
+		status = types.DeliverySucceeded
+	} else if attempt.RetryIn == 0 {
+		status = types.DeliveryFailed
+	}
+	_, err := s.db.Exec(`update webhook_delivery set status = $2, attempts = attempts + 1,
+				last_status_code = nullif($3, 0), last_error = nullif($4, ''),
+				next_attempt_at = case when $2 = 'pending' then now() + make_interval(secs => $5) end,
+				delivered_at = case when $2 = 'succeeded' then now() end
+			where id = $1`,
+		id, status, attempt.StatusCode, truncateError(attempt.Error), int(attempt.RetryIn.Seconds()))
+	return err
+}
+
+func truncateError(msg string) string {
+	if len(msg) > 500 {
+		return msg[:500]
+	}
+	return msg
+}
+
+// ReplayDelivery sends the event of a delivery to its subscription again as
+// a new delivery, leaving the log of the old one untouched.
+func (s *PostgresStore) ReplayDelivery(id int) (*types.WebhookDelivery, error) {
+	var newID int
+	err := s.db.QueryRow(`insert into webhook_delivery (subscriptionID, eventID, status, next_attempt_at)
+			select subscriptionID, eventID, $2, now() from webhook_delivery where id = $1
+			returning id`, id, types.DeliveryPending).Scan(&newID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("delivery %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetWebhookDelivery(newID)
+}
+
+func (s *PostgresStore) GetWebhookDelivery(id int) (*types.WebhookDelivery, error) {
+	deliveries, err := s.queryDeliveries(deliverySelect(`webhook_delivery`)+` where d.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, fmt.Errorf("delivery %d not found", id)
+	}
+	return deliveries[0], nil
+}
+
+// GetWebhookDeliveries returns the delivery log of a subscription, newest
+// first. An empty status matches every delivery.
+func (s *PostgresStore) GetWebhookDeliveries(subscriptionID int, status types.DeliveryStatus) ([]*types.WebhookDelivery, error) {
+	return s.queryDeliveries(deliverySelect(`webhook_delivery`)+` where d.subscriptionID = $1 and ($2 = '' or d.status = $2)
+			order by d.id desc limit 100`, subscriptionID, status)
+}
+
+func deliverySelect(from string) string {
+	return `select d.id, d.subscriptionID, d.status, d.attempts, d.next_attempt_at, coalesce(d.last_status_code, 0),
+				coalesce(d.last_error, ''), d.created_at, d.delivered_at, e.id, e.type, e.data, e.created_at
+			from ` + from + ` d join outbox_event e on e.id = d.eventID`
+}
+
+func (s *PostgresStore) queryDeliveries(query string, args ...any) ([]*types.WebhookDelivery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*types.WebhookDelivery{}
+	for rows.Next() {
+		d := &types.WebhookDelivery{Event: new(types.Event)}
+		var next, delivered sql.NullTime
+		var data []byte
+		err := rows.Scan(
+			&d.ID,
+			&d.SubscriptionID,
+			&d.Status,
+			&d.Attempts,
+			&next,
+			&d.LastStatusCode,
+			&d.LastError,
+			&d.CreatedAt,
+			&delivered,
+			&d.Event.ID,
+			&d.Event.Type,
+			&data,
+			&d.Event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Event.Data = data
+		if next.Valid {
+			d.NextAttemptAt = &next.Time
+		}
+		if delivered.Valid {
+			d.DeliveredAt = &delivered.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *PostgresStore) CreateWebhookSubscription(sub *types.WebhookSubscription) error {
+	return s.db.QueryRow(`insert into webhook_subscription (url, events, secret, active)
+			values ($1, $2, $3, $4) returning id, created_at`,
+		sub.URL, pq.Array(eventStrings(sub.Events)), sub.Secret, sub.Active).Scan(&sub.ID, &sub.CreatedAt)
+}
+
+// UpdateWebhookSubscription changes the URL, events and active flag of a
+// subscription, and its secret unless sub.Secret is empty.
+func (s *PostgresStore) UpdateWebhookSubscription(sub *types.WebhookSubscription) error {
+	res, err := s.db.Exec(`update webhook_subscription set url = $2, events = $3, active = $4,
+				secret = coalesce(nullif($5, ''), secret)
+			where id = $1`, sub.ID, sub.URL, pq.Array(eventStrings(sub.Events)), sub.Active, sub.Secret)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("webhook %d not found", sub.ID)
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteWebhookSubscription(id int) error {
+	res, err := s.db.Exec(`delete from webhook_subscription where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("webhook %d not found", id)
+	}
+	return nil
+}
+
+func eventStrings(events []types.EventType) []string {
+	strs := make([]string, len(events))
+	for i, e := range events {
+		strs[i] = string(e)
+	}
+	return strs
+}
+
+const subscriptionSelect = `select id, url, events, secret, active, created_at from webhook_subscription`
+
+func (s *PostgresStore) GetWebhookSubscriptions() ([]*types.WebhookSubscription, error) {
+	return s.querySubscriptions(subscriptionSelect + ` order by id`)
+}
+
+func (s *PostgresStore) GetWebhookSubscription(id int) (*types.WebhookSubscription, error) {
+	subs, err := s.querySubscriptions(subscriptionSelect+` where id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("webhook %d not found", id)
+	}
+	return subs[0], nil
+}
+
+func (s *PostgresStore) querySubscriptions(query string, args ...any) ([]*types.WebhookSubscription, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*types.WebhookSubscription{}
+	for rows.Next() {
+		sub := new(types.WebhookSubscription)
+		var events []string
+		err := rows.Scan(&sub.ID, &sub.URL, pq.Array(&events), &sub.Secret, &sub.Active, &sub.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			sub.Events = append(sub.Events, types.EventType(e))
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
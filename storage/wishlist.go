@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"3legant/types"
+	"database/sql"
+	"fmt"
+)
+
+func (s *PostgresStore) CreateWishlistTable() error {
+	query := `create table if not exists wishlist(
+			id serial primary key,
+			accID integer references account(id) on delete cascade,
+			name varchar(50),
+			share_token varchar(64) unique
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateWishlistProductTable() error {
+	query := `create table if not exists wishlist_product(
+			wishlist_id integer references wishlist(id) on delete cascade,
+			product_id integer references product(id) on delete cascade,
+			constraint wishlist_product_pk primary key (wishlist_id, product_id)
+		)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateWishlist(wishlist *types.Wishlist) error {
+	query := `insert into wishlist (accID, name) values ($1, $2) returning id`
+	return s.db.QueryRow(query, wishlist.AccID, wishlist.Name).Scan(&wishlist.ID)
+}
+
+func (s *PostgresStore) GetWishlistsByAccountID(accID int) ([]*types.Wishlist, error) {
+	rows, err := s.db.Query(`select id, accID, name, coalesce(share_token, '') from wishlist where accID = $1 order by id`, accID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wishlists := []*types.Wishlist{}
+	for rows.Next() {
+		wishlist, err := scanIntoWishlist(rows)
+		if err != nil {
+			return nil, err
+		}
+		wishlists = append(wishlists, wishlist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, wishlist := range wishlists {
+		if wishlist.Products, err = s.getWishlistProducts(wishlist.ID); err != nil {
+			return nil, err
+		}
+	}
+	return wishlists, nil
+}
+
+func (s *PostgresStore) GetWishlist(accID, wishlistID int) (*types.Wishlist, error) {
+	row := s.db.QueryRow(`select id, accID, name, coalesce(share_token, '') from wishlist where id = $1 and accID = $2`,
+		wishlistID, accID)
+	return s.getWishlistFromRow(row, fmt.Errorf("wishlist %d not found", wishlistID))
+}
+
+func (s *PostgresStore) GetWishlistByShareToken(token string) (*types.Wishlist, error) {
+	row := s.db.QueryRow(`select id, accID, name, coalesce(share_token, '') from wishlist where share_token = $1`, token)
+	return s.getWishlistFromRow(row, fmt.Errorf("wishlist not found"))
+}
+
+func (s *PostgresStore) getWishlistFromRow(row *sql.Row, notFound error) (*types.Wishlist, error) {
+	wishlist := new(types.Wishlist)
+	err := row.Scan(&wishlist.ID, &wishlist.AccID, &wishlist.Name, &wishlist.ShareToken)
+	if err == sql.ErrNoRows {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if wishlist.Products, err = s.getWishlistProducts(wishlist.ID); err != nil {
+		return nil, err
+	}
+	return wishlist, nil
+}
+
+func (s *PostgresStore) getWishlistProducts(wishlistID int) ([]*types.Product, error) {
+	rows, err := s.db.Query(`select product_id from wishlist_product where wishlist_id = $1 order by product_id`, wishlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	products := []*types.Product{}
+	for _, id := range ids {
+		product, err := s.GetProductByID(id)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+func (s *PostgresStore) UpdateWishlist(accID, wishlistID int, name string) error {
+	res, err := s.db.Exec(`update wishlist set name = $3 where id = $1 and accID = $2`, wishlistID, accID, name)
+	return checkWishlistAffected(res, err, wishlistID)
+}
+
+func (s *PostgresStore) DeleteWishlist(accID, wishlistID int) error {
+	res, err := s.db.Exec(`delete from wishlist where id = $1 and accID = $2`, wishlistID, accID)
+	return checkWishlistAffected(res, err, wishlistID)
+}
+
+// SetWishlistShareToken stores the token that grants read-only access to the
+// wishlist. An empty token revokes sharing.
+func (s *PostgresStore) SetWishlistShareToken(accID, wishlistID int, token string) error {
+	res, err := s.db.Exec(`update wishlist set share_token = nullif($3, '') where id = $1 and accID = $2`,
+		wishlistID, accID, token)
+	return checkWishlistAffected(res, err, wishlistID)
+}
+
+func (s *PostgresStore) AddProductToWishlist(accID, wishlistID, prodID int) error {
+	if _, err := s.GetWishlist(accID, wishlistID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`insert into wishlist_product (wishlist_id, product_id) values ($1, $2)
+			on conflict do nothing`, wishlistID, prodID)
+	return err
+}
+
+func (s *PostgresStore) DeleteProductFromWishlist(accID, wishlistID, prodID int) error {
+	res, err := s.db.Exec(`delete from wishlist_product wp using wishlist w
+			where wp.wishlist_id = w.id and w.id = $1 and w.accID = $2 and wp.product_id = $3`,
+		wishlistID, accID, prodID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("product %d not in wishlist %d", prodID, wishlistID)
+	}
+	return nil
+}
+
+// MoveCartProductToWishlist removes the product from the account's cart and
+// adds it to the wishlist.
+func (s *PostgresStore) MoveCartProductToWishlist(accID, wishlistID, prodID int) error {
+	if _, err := s.GetWishlist(accID, wishlistID); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`delete from cart_product cp using cart c
+			where cp.cart_id = c.id and c.user_id = $1 and cp.product_id = $2`, accID, prodID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("product %d not in cart", prodID)
+	}
+	if _, err := tx.Exec(`update cart set updated_at = now() where user_id = $1`, accID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`insert into wishlist_product (wishlist_id, product_id) values ($1, $2)
+			on conflict do nothing`, wishlistID, prodID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveWishlistProductToCart removes the product from the wishlist and adds
+// quantity of it to the account's cart. Nothing changes if the product is no
+// longer sold or not enough of it is in stock.
+func (s *PostgresStore) MoveWishlistProductToCart(accID, wishlistID, prodID, quantity int) error {
+	cart, err := s.GetCartByUserID(accID)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`delete from wishlist_product wp using wishlist w
+			where wp.wishlist_id = w.id and w.id = $1 and w.accID = $2 and wp.product_id = $3`,
+		wishlistID, accID, prodID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("product %d not in wishlist %d", prodID, wishlistID)
+	}
+	product, err := lockProduct(tx, prodID)
+	if err != nil {
+		return err
+	}
+	var total int
+	err = tx.QueryRow(`insert into cart_product (cart_id, product_id, quantity, unit_price) values ($1, $2, $3, $4)
+			on conflict (cart_id, product_id) do update
+			set quantity = cart_product.quantity + excluded.quantity,
+				unit_price = coalesce(cart_product.unit_price, excluded.unit_price)
+			returning quantity`,
+		cart.CartID, prodID, quantity, product.price).Scan(&total)
+	if err != nil {
+		return err
+	}
+	if product.stock.Valid && int64(total) > product.stock.Int64 {
+		return fmt.Errorf("only %d of product %d in stock", product.stock.Int64, prodID)
+	}
+	if err := touchCart(tx, cart.CartID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func checkWishlistAffected(res sql.Result, err error, wishlistID int) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("wishlist %d not found", wishlistID)
+	}
+	return nil
+}
+
+func scanIntoWishlist(rows *sql.Rows) (*types.Wishlist, error) {
+	wishlist := new(types.Wishlist)
+	err := rows.Scan(
+		&wishlist.ID,
+		&wishlist.AccID,
+		&wishlist.Name,
+		&wishlist.ShareToken)
+	return wishlist, err
+}
@@ -0,0 +1,234 @@
+package tax
+
+import (
+	"3legant/money"
+	"3legant/types"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rule is the rate charged on products of a tax class shipped to a country
+// or region. Empty fields match anything, so a rule without Country applies
+// wherever no more specific rule does.
+type Rule struct {
+	Country string
+	Region  string
+	Class   types.TaxClass
+	RateBP  int
+	// Name labels the rule in breakdowns, such as "CA sales tax".
+	Name string
+}
+
+// specificity ranks rules matching the same product and destination.
+// Country outweighs region, which outweighs class.
+func (r Rule) specificity() int {
+	n := 0
+	if r.Country != "" {
+		n += 4
+	}
+	if r.Region != "" {
+		n += 2
+	}
+	if r.Class != "" {
+		n++
+	}
+	return n
+}
+
+func (r Rule) label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	place := "Tax"
+	if r.Country != "" {
+		place = r.Country
+		if r.Region != "" {
+			place += "-" + r.Region
+		}
+	}
+	return fmt.Sprintf("%s %s%%", place, FormatRate(r.RateBP))
+}
+
+type Rounding string
+
+const (
+	// RoundPerLine rounds the tax of every cart line and adds them up.
+	RoundPerLine Rounding = "line"
+	// RoundPerTotal adds up the lines taxed at a rate and rounds once.
+	RoundPerTotal Rounding = "total"
+)
+
+func ParseRounding(str string) (Rounding, error) {
+	switch Rounding(str) {
+	case RoundPerLine, RoundPerTotal:
+		return Rounding(str), nil
+	}
+	return "", fmt.Errorf("invalid tax rounding %q, want line or total", str)
+}
+
+// Calculator taxes cart lines by the rules matching the cart's destination,
+// or Home if the cart has none. Inclusive means product prices already
+// contain tax. Shipping is not taxed.
+type Calculator struct {
+	Rules     []Rule
+	Inclusive bool
+	Rounding  Rounding
+	Home      types.Destination
+}
+
+// Rule returns the most specific rule for the class at dest. Zero rated
+// products have none.
+func (c *Calculator) Rule(dest types.Destination, class types.TaxClass) (Rule, bool) {
+	var best Rule
+	found := false
+	if class == types.TaxClassZero {
+		return best, false
+	}
+	for _, r := range c.Rules {
+		if r.Country != "" && r.Country != dest.Country {
+			continue
+		}
+		if r.Region != "" && !strings.EqualFold(r.Region, dest.Region) {
+			continue
+		}
+		if r.Class != "" && r.Class != class {
+			continue
+		}
+		if !found || r.specificity() > best.specificity() {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// CalculateTax fills in the tax of every line, the breakdown by rate and
+// the cart's total tax. Lines are taxed on their discounted totals.
+func (c *Calculator) CalculateTax(summary *types.CartSummary) error {
+	dest := summary.Destination
+	if dest.Country == "" {
+		dest = c.Home
+	}
+
+	type group struct {
+		tax   *types.TaxLine
+		lines []*types.CartLine
+	}
+	type key struct {
+		name   string
+		rateBP int
+	}
+	var groups []*group
+	byRule := map[key]*group{}
+	for _, line := range summary.Lines {
+		line.Tax = 0
+		class := line.Product.TaxClass
+		if class == "" {
+			class = types.TaxClassStandard
+		}
+		rule, ok := c.Rule(dest, class)
+		if !ok || rule.RateBP == 0 {
+			continue
+		}
+		k := key{rule.label(), rule.RateBP}
+		g := byRule[k]
+		if g == nil {
+			g = &group{tax: &types.TaxLine{Name: k.name, RateBP: k.rateBP}}
+			byRule[k] = g
+			groups = append(groups, g)
+		}
+		g.tax.Taxable += line.LineTotal
+		g.lines = append(g.lines, line)
+		if c.Rounding != RoundPerTotal {
+			line.Tax = c.tax(line.LineTotal, rule.RateBP)
+			g.tax.Amount += line.Tax
+		}
+	}
+
+	summary.Taxes = []*types.TaxLine{}
+	summary.Tax = 0
+	summary.TaxIncluded = c.Inclusive
+	for _, g := range groups {
+		if c.Rounding == RoundPerTotal {
+			g.tax.Amount = c.tax(g.tax.Taxable, g.tax.RateBP)
+			// spread the rounded tax so the lines still add up to it
+			weights := make([]money.Amount, len(g.lines))
+			for i, line := range g.lines {
+				weights[i] = line.LineTotal
+			}
+			for i, part := range g.tax.Amount.Split(weights) {
+				g.lines[i].Tax = part
+			}
+		}
+		if c.Inclusive {
+			g.tax.Taxable -= g.tax.Amount
+		}
+		summary.Taxes = append(summary.Taxes, g.tax)
+		summary.Tax += g.tax.Amount
+	}
+	return nil
+}
+
+func (c *Calculator) tax(amount money.Amount, bp int) money.Amount {
+	if c.Inclusive {
+		return amount.IncludedTax(bp)
+	}
+	return amount.MulRate(bp)
+}
+
+// ParseRules reads a comma separated list of rules in the form
+// country[/region][:class]=rate, where the rate is in basis points and
+// country * matches everywhere. "US/CA=725,DE=1900,DE:reduced=700" charges
+// 7.25% in California and 19% in Germany, 7% on reduced rate products.
+func ParseRules(spec string) ([]Rule, error) {
+	rules := []Rule{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		where, rate, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tax rule %q: missing rate", part)
+		}
+		bp, err := strconv.Atoi(strings.TrimSpace(rate))
+		if err != nil || bp < 0 || bp > 10000 {
+			return nil, fmt.Errorf("invalid tax rule %q: rate must be 0 to 10000 basis points", part)
+		}
+		rule := Rule{RateBP: bp}
+		where, class, hasClass := strings.Cut(where, ":")
+		if hasClass {
+			if rule.Class, err = ParseClass(class); err != nil {
+				return nil, err
+			}
+		}
+		country, region, _ := strings.Cut(strings.TrimSpace(where), "/")
+		if country != "*" {
+			if len(country) != 2 {
+				return nil, fmt.Errorf("invalid tax rule %q: country must be a two letter code or *", part)
+			}
+			rule.Country = strings.ToUpper(country)
+			rule.Region = strings.ToUpper(region)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ParseClass checks a product's tax class. Products without one are taxed
+// at the standard rate.
+func ParseClass(str string) (types.TaxClass, error) {
+	switch class := types.TaxClass(strings.ToLower(strings.TrimSpace(str))); class {
+	case "":
+		return types.TaxClassStandard, nil
+	case types.TaxClassStandard, types.TaxClassReduced, types.TaxClassZero:
+		return class, nil
+	}
+	return "", fmt.Errorf("invalid tax class %q", str)
+}
+
+// FormatRate writes basis points as a percentage without trailing zeros,
+// 725 as 7.25 and 2000 as 20.
+func FormatRate(bp int) string {
+	return strconv.FormatFloat(float64(bp)/100, 'f', -1, 64)
+}
@@ -0,0 +1,150 @@
+package tax
+
+import (
+	"3legant/money"
+	"3legant/types"
+	"reflect"
+	"testing"
+)
+
+func cart(dest types.Destination, lines ...*types.CartLine) *types.CartSummary {
+	return &types.CartSummary{Destination: dest, Lines: lines}
+}
+
+func line(class types.TaxClass, total money.Amount) *types.CartLine {
+	return &types.CartLine{Product: &types.Product{TaxClass: class}, Quantity: 1, LineTotal: total}
+}
+
+func lineTaxes(summary *types.CartSummary) []money.Amount {
+	taxes := []money.Amount{}
+	for _, line := range summary.Lines {
+		taxes = append(taxes, line.Tax)
+	}
+	return taxes
+}
+
+func TestRule(t *testing.T) {
+	rules, err := ParseRules("*=1000,US=500,US/CA=725,DE=1900,DE:reduced=700")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Calculator{Rules: rules}
+	tests := []struct {
+		dest   types.Destination
+		class  types.TaxClass
+		want   int
+		wantOK bool
+	}{
+		{types.Destination{Country: "US", Region: "CA"}, types.TaxClassStandard, 725, true},
+		{types.Destination{Country: "US", Region: "ca"}, types.TaxClassStandard, 725, true},
+		{types.Destination{Country: "US", Region: "NY"}, types.TaxClassStandard, 500, true},
+		{types.Destination{Country: "US", Region: "CA"}, types.TaxClassReduced, 725, true},
+		{types.Destination{Country: "DE"}, types.TaxClassStandard, 1900, true},
+		{types.Destination{Country: "DE"}, types.TaxClassReduced, 700, true},
+		{types.Destination{Country: "FR"}, types.TaxClassReduced, 1000, true},
+		{types.Destination{Country: "DE"}, types.TaxClassZero, 0, false},
+	}
+	for _, tt := range tests {
+		rule, ok := c.Rule(tt.dest, tt.class)
+		if ok != tt.wantOK || rule.RateBP != tt.want {
+			t.Errorf("Rule(%+v, %s) = %d, %v, want %d, %v", tt.dest, tt.class, rule.RateBP, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCalculateTaxRounding(t *testing.T) {
+	us := types.Destination{Country: "US"}
+	tests := []struct {
+		name      string
+		rounding  Rounding
+		wantLines []money.Amount
+		wantTax   money.Amount
+	}{
+		// 10% of 0.05 is half a cent on every line
+		{"per line", RoundPerLine, []money.Amount{1, 1, 1}, 3},
+		{"per total", RoundPerTotal, []money.Amount{1, 1, 0}, 2},
+	}
+	for _, tt := range tests {
+		c := &Calculator{Rules: []Rule{{Country: "US", RateBP: 1000}}, Rounding: tt.rounding}
+		summary := cart(us, line("", 5), line("", 5), line("", 5))
+		if err := c.CalculateTax(summary); err != nil {
+			t.Fatal(err)
+		}
+		if got := lineTaxes(summary); !reflect.DeepEqual(got, tt.wantLines) {
+			t.Errorf("%s: line taxes = %v, want %v", tt.name, got, tt.wantLines)
+		}
+		if summary.Tax != tt.wantTax || len(summary.Taxes) != 1 || summary.Taxes[0].Amount != tt.wantTax {
+			t.Errorf("%s: tax = %v %+v, want %v", tt.name, summary.Tax, summary.Taxes, tt.wantTax)
+		}
+	}
+}
+
+func TestCalculateTaxInclusive(t *testing.T) {
+	c := &Calculator{Rules: []Rule{{RateBP: 2000}}, Inclusive: true, Rounding: RoundPerLine}
+	summary := cart(types.Destination{Country: "GB"}, line(types.TaxClassStandard, 12000), line(types.TaxClassZero, 500))
+	if err := c.CalculateTax(summary); err != nil {
+		t.Fatal(err)
+	}
+	if !summary.TaxIncluded {
+		t.Error("TaxIncluded = false, want true")
+	}
+	if got, want := lineTaxes(summary), []money.Amount{2000, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("line taxes = %v, want %v", got, want)
+	}
+	if len(summary.Taxes) != 1 || summary.Taxes[0].Taxable != 10000 || summary.Taxes[0].Amount != 2000 {
+		t.Errorf("taxes = %+v, want 100.00 taxable and 20.00 tax", summary.Taxes[0])
+	}
+}
+
+func TestCalculateTaxBreakdown(t *testing.T) {
+	rules, err := ParseRules("DE=1900,DE:reduced=700")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Calculator{Rules: rules, Rounding: RoundPerLine, Home: types.Destination{Country: "DE"}}
+	// no destination yet, taxed as if shipped within the home country
+	summary := cart(types.Destination{}, line("", 1000), line(types.TaxClassReduced, 1000), line(types.TaxClassStandard, 2000))
+	if err := c.CalculateTax(summary); err != nil {
+		t.Fatal(err)
+	}
+	want := []*types.TaxLine{
+		{Name: "DE 19%", RateBP: 1900, Taxable: 3000, Amount: 570},
+		{Name: "DE 7%", RateBP: 700, Taxable: 1000, Amount: 70},
+	}
+	if !reflect.DeepEqual(summary.Taxes, want) {
+		t.Errorf("taxes = %+v %+v, want %+v %+v", summary.Taxes[0], summary.Taxes[1], want[0], want[1])
+	}
+	if summary.Tax != 640 {
+		t.Errorf("tax = %v, want 6.40", summary.Tax)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(" us/ca=725, DE:reduced=700 ,*=1000,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{Country: "US", Region: "CA", RateBP: 725},
+		{Country: "DE", Class: types.TaxClassReduced, RateBP: 700},
+		{RateBP: 1000},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("ParseRules = %+v, want %+v", rules, want)
+	}
+
+	for _, spec := range []string{"US", "USA=500", "US=abc", "US=-1", "US=10001", "DE:luxury=2500"} {
+		if _, err := ParseRules(spec); err == nil {
+			t.Errorf("ParseRules(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := map[int]string{725: "7.25", 2000: "20", 1950: "19.5", 0: "0"}
+	for bp, want := range tests {
+		if got := FormatRate(bp); got != want {
+			t.Errorf("FormatRate(%d) = %q, want %q", bp, got, want)
+		}
+	}
+}
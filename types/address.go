@@ -0,0 +1,58 @@
+package types
+
+type AddressKind string
+
+const (
+	AddressShipping AddressKind = "shipping"
+	AddressBilling  AddressKind = "billing"
+)
+
+// Address is an entry of an account's address book. Default marks the
+// address used when the customer picks none, per kind.
+type Address struct {
+	ID         int         `json:"id"`
+	AccID      int         `json:"accID"`
+	Kind       AddressKind `json:"kind"`
+	Name       string      `json:"name"`
+	Line1      string      `json:"line1"`
+	Line2      string      `json:"line2,omitempty"`
+	City       string      `json:"city"`
+	Region     string      `json:"region,omitempty"`
+	PostalCode string      `json:"postalCode"`
+	Country    string      `json:"country"`
+	Phone      string      `json:"phone,omitempty"`
+	Default    bool        `json:"default"`
+}
+
+func (a *Address) Destination() Destination {
+	return Destination{Country: a.Country, Region: a.Region, PostalCode: a.PostalCode}
+}
+
+type AddressRequest struct {
+	Kind       AddressKind `json:"kind"`
+	Name       string      `json:"name"`
+	Line1      string      `json:"line1"`
+	Line2      string      `json:"line2"`
+	City       string      `json:"city"`
+	Region     string      `json:"region"`
+	PostalCode string      `json:"postalCode"`
+	Country    string      `json:"country"`
+	Phone      string      `json:"phone"`
+	Default    bool        `json:"default"`
+}
+
+func NewAddress(accID int, req *AddressRequest) *Address {
+	return &Address{
+		AccID:      accID,
+		Kind:       req.Kind,
+		Name:       req.Name,
+		Line1:      req.Line1,
+		Line2:      req.Line2,
+		City:       req.City,
+		Region:     req.Region,
+		PostalCode: req.PostalCode,
+		Country:    req.Country,
+		Phone:      req.Phone,
+		Default:    req.Default,
+	}
+}
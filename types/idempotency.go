@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// IdempotencyRecord remembers a request made with an Idempotency-Key and,
+// once it completed, the response to replay to retries. Status is zero
+// while the request is still being handled.
+type IdempotencyRecord struct {
+	Scope       string
+	Key         string
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
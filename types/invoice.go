@@ -0,0 +1,19 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// Invoice is issued once for every paid order. Seq numbers invoices without
+// gaps, as tax authorities expect.
+type Invoice struct {
+	ID       int       `json:"id"`
+	Seq      int       `json:"-"`
+	OrderID  int       `json:"orderID"`
+	IssuedAt time.Time `json:"issuedAt"`
+}
+
+func (i *Invoice) Number() string {
+	return fmt.Sprintf("INV-%06d", i.Seq)
+}
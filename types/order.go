@@ -0,0 +1,126 @@
+package types
+
+import (
+	"3legant/money"
+	"time"
+)
+
+type OrderStatus string
+
+const (
+	OrderPendingPayment OrderStatus = "pending_payment"
+	OrderPaid           OrderStatus = "paid"
+	OrderProcessing     OrderStatus = "processing"
+	OrderShipped        OrderStatus = "shipped"
+	OrderDelivered      OrderStatus = "delivered"
+	OrderCancelled      OrderStatus = "cancelled"
+	OrderRefunded       OrderStatus = "refunded"
+)
+
+// Order is a placed cart. Prices are copied from the cart when the order is
+// placed and do not follow later product changes.
+type Order struct {
+	ID              int               `json:"id"`
+	AccID           int               `json:"accID"`
+	Status          OrderStatus       `json:"status"`
+	Lines           []*OrderLine      `json:"lines"`
+	Discounts       []*CartAdjustment `json:"discounts"`
+	Subtotal        money.Amount      `json:"subtotal"`
+	Discount        money.Amount      `json:"discount"`
+	Tax             money.Amount      `json:"tax"`
+	Taxes           []*TaxLine        `json:"taxes"`
+	TaxIncluded     bool              `json:"taxIncluded"`
+	Shipping        money.Amount      `json:"shipping"`
+	ShippingMethod  string            `json:"shippingMethod"`
+	Destination     Destination       `json:"destination"`
+	ShippingAddress *Address          `json:"shippingAddress,omitempty"`
+	BillingAddress  *Address          `json:"billingAddress,omitempty"`
+	Total           money.Amount      `json:"total"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	History         []*OrderEvent     `json:"history"`
+	Payments        []*Payment        `json:"payments"`
+	Returns         []*Return         `json:"returns"`
+	Promotions      []int             `json:"-"`
+}
+
+// OrderEvent records a status change of an order. From is empty for the
+// event that created the order.
+type OrderEvent struct {
+	From      OrderStatus `json:"from,omitempty"`
+	To        OrderStatus `json:"to"`
+	ActorID   int         `json:"actorID"`
+	Note      string      `json:"note,omitempty"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+type OrderTransitionRequest struct {
+	Status OrderStatus `json:"status"`
+	Note   string      `json:"note"`
+}
+
+type OrderLine struct {
+	ID        int          `json:"id"`
+	ProdID    int          `json:"prodID"`
+	Name      string       `json:"name"`
+	Quantity  int          `json:"quantity"`
+	UnitPrice money.Amount `json:"unitPrice"`
+	Discount  money.Amount `json:"discount"`
+	LineTotal money.Amount `json:"lineTotal"`
+	Tax       money.Amount `json:"tax"`
+}
+
+type CheckoutRequest struct {
+	// AcceptPriceChanges places the order even if prices changed since the
+	// products were put in the cart.
+	AcceptPriceChanges bool `json:"acceptPriceChanges"`
+	// PaymentToken pays for the order right away. Without it the order
+	// waits for payment.
+	PaymentToken string `json:"paymentToken"`
+	// ShippingAddressID and BillingAddressID pick entries of the address
+	// book. Zero means the default address of that kind; billing falls back
+	// to the shipping address.
+	ShippingAddressID int `json:"shippingAddressID"`
+	BillingAddressID  int `json:"billingAddressID"`
+}
+
+type OrderQuery struct {
+	AccID  int
+	Status OrderStatus
+}
+
+// NewOrder copies the priced cart into an order.
+func NewOrder(summary *CartSummary) *Order {
+	order := &Order{
+		AccID:          summary.UserID,
+		Status:         OrderPendingPayment,
+		Lines:          []*OrderLine{},
+		Discounts:      []*CartAdjustment{},
+		Subtotal:       summary.Subtotal,
+		Discount:       summary.Discount,
+		Tax:            summary.Tax,
+		Taxes:          summary.Taxes,
+		TaxIncluded:    summary.TaxIncluded,
+		Shipping:       summary.Shipping,
+		ShippingMethod: summary.ShippingMethod,
+		Destination:    summary.Destination,
+		Total:          summary.Total,
+	}
+	for _, line := range summary.Lines {
+		order.Lines = append(order.Lines, &OrderLine{
+			ProdID:    line.Product.ID,
+			Name:      line.Product.Name,
+			Quantity:  line.Quantity,
+			UnitPrice: line.UnitPrice,
+			Discount:  line.Discount,
+			LineTotal: line.LineTotal,
+			Tax:       line.Tax,
+		})
+	}
+	for _, d := range summary.Discounts {
+		order.Discounts = append(order.Discounts, d)
+		if d.PromotionID != 0 {
+			order.Promotions = append(order.Promotions, d.PromotionID)
+		}
+	}
+	return order
+}
@@ -0,0 +1,24 @@
+package types
+
+import (
+	"3legant/money"
+	"time"
+)
+
+// Payment is a payment attempt for an order. Status mirrors the provider's
+// intent status.
+type Payment struct {
+	ID            int          `json:"id"`
+	OrderID       int          `json:"orderID"`
+	IntentID      string       `json:"intentID"`
+	Status        string       `json:"status"`
+	Amount        money.Amount `json:"amount"`
+	Refunded      money.Amount `json:"refunded"`
+	NextAction    string       `json:"nextAction,omitempty"`
+	DeclineReason string       `json:"declineReason,omitempty"`
+	CreatedAt     time.Time    `json:"createdAt"`
+}
+
+type PayOrderRequest struct {
+	Token string `json:"token"`
+}
@@ -0,0 +1,77 @@
+package types
+
+import (
+	"3legant/money"
+	"time"
+)
+
+type PromotionKind string
+
+const (
+	PromotionPercentOff   PromotionKind = "percent_off"
+	PromotionFixedOff     PromotionKind = "fixed_off"
+	PromotionBuyXGetY     PromotionKind = "buy_x_get_y"
+	PromotionFreeShipping PromotionKind = "free_shipping"
+)
+
+// Promotion is a discount rule. Promotions without a Code apply
+// automatically, the others only once the coupon is applied to a cart.
+type Promotion struct {
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Code        string        `json:"code,omitempty"`
+	Kind        PromotionKind `json:"kind"`
+	Percent     int           `json:"percent,omitempty"`
+	AmountOff   money.Amount  `json:"amountOff,omitempty"`
+	BuyQuantity int           `json:"buyQuantity,omitempty"`
+	GetQuantity int           `json:"getQuantity,omitempty"`
+
+	MinSubtotal      money.Amount `json:"minSubtotal,omitempty"`
+	Category         string       `json:"category,omitempty"`
+	UsageLimit       int          `json:"usageLimit,omitempty"`
+	PerCustomerLimit int          `json:"perCustomerLimit,omitempty"`
+	TimesUsed        int          `json:"timesUsed"`
+	StartsAt         *time.Time   `json:"startsAt,omitempty"`
+	EndsAt           *time.Time   `json:"endsAt,omitempty"`
+	Active           bool         `json:"active"`
+}
+
+type CreatePromotionRequest struct {
+	Name             string        `json:"name"`
+	Code             string        `json:"code"`
+	Kind             PromotionKind `json:"kind"`
+	Percent          int           `json:"percent"`
+	AmountOff        money.Amount  `json:"amountOff"`
+	BuyQuantity      int           `json:"buyQuantity"`
+	GetQuantity      int           `json:"getQuantity"`
+	MinSubtotal      money.Amount  `json:"minSubtotal"`
+	Category         string        `json:"category"`
+	UsageLimit       int           `json:"usageLimit"`
+	PerCustomerLimit int           `json:"perCustomerLimit"`
+	StartsAt         *time.Time    `json:"startsAt"`
+	EndsAt           *time.Time    `json:"endsAt"`
+	Active           bool          `json:"active"`
+}
+
+type ApplyCouponRequest struct {
+	Code string `json:"code"`
+}
+
+func NewPromotion(req *CreatePromotionRequest) *Promotion {
+	return &Promotion{
+		Name:             req.Name,
+		Code:             req.Code,
+		Kind:             req.Kind,
+		Percent:          req.Percent,
+		AmountOff:        req.AmountOff,
+		BuyQuantity:      req.BuyQuantity,
+		GetQuantity:      req.GetQuantity,
+		MinSubtotal:      req.MinSubtotal,
+		Category:         req.Category,
+		UsageLimit:       req.UsageLimit,
+		PerCustomerLimit: req.PerCustomerLimit,
+		StartsAt:         req.StartsAt,
+		EndsAt:           req.EndsAt,
+		Active:           req.Active,
+	}
+}
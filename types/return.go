@@ -0,0 +1,53 @@
+package types
+
+import (
+	"3legant/money"
+	"time"
+)
+
+type ReturnStatus string
+
+const (
+	ReturnRequested ReturnStatus = "requested"
+	ReturnApproved  ReturnStatus = "approved"
+	ReturnRejected  ReturnStatus = "rejected"
+	ReturnReceived  ReturnStatus = "received"
+	ReturnRefunded  ReturnStatus = "refunded"
+)
+
+// Return is a customer's request to send back part of an order.
+type Return struct {
+	ID      int           `json:"id"`
+	OrderID int           `json:"orderID"`
+	AccID   int           `json:"accID"`
+	Status  ReturnStatus  `json:"status"`
+	Note    string        `json:"note,omitempty"`
+	Lines   []*ReturnLine `json:"lines"`
+	// Label is the return shipping label reference, set on approval.
+	Label           string       `json:"label,omitempty"`
+	RejectionReason string       `json:"rejectionReason,omitempty"`
+	RefundAmount    money.Amount `json:"refundAmount"`
+	CreatedAt       time.Time    `json:"createdAt"`
+	UpdatedAt       time.Time    `json:"updatedAt"`
+}
+
+type ReturnLine struct {
+	OrderLineID int    `json:"orderLineID"`
+	ProdID      int    `json:"prodID"`
+	Quantity    int    `json:"quantity"`
+	Reason      string `json:"reason"`
+}
+
+type CreateReturnRequest struct {
+	Lines []*ReturnLine `json:"lines"`
+	Note  string        `json:"note"`
+}
+
+type RejectReturnRequest struct {
+	Reason string `json:"reason"`
+}
+
+type RefundReturnRequest struct {
+	// Amount defaults to the value of the returned lines.
+	Amount *money.Amount `json:"amount"`
+}
@@ -0,0 +1,23 @@
+package types
+
+import "3legant/money"
+
+// TaxClass groups products that are taxed at the same rate, such as books
+// that get a reduced rate in many countries.
+type TaxClass string
+
+const (
+	TaxClassStandard TaxClass = "standard"
+	TaxClassReduced  TaxClass = "reduced"
+	// TaxClassZero is never taxed.
+	TaxClassZero TaxClass = "zero"
+)
+
+// TaxLine is one row of a tax breakdown: how much was taxed at a rate and
+// the tax that came to.
+type TaxLine struct {
+	Name    string       `json:"name"`
+	RateBP  int          `json:"rateBP"`
+	Taxable money.Amount `json:"taxable"`
+	Amount  money.Amount `json:"amount"`
+}
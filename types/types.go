@@ -1,7 +1,9 @@
 package types
 
 import (
+	"3legant/money"
 	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 type UserType string
@@ -27,6 +29,16 @@ type Product struct {
 	Measurements string  `json:"measurements"`
 	Description  string  `json:"description"`
 	Packaging    string  `json:"packaging"`
+	// Stock is nil for products whose stock is not tracked.
+	Stock *int `json:"stock"`
+	// Weight in kg, nil if unknown.
+	Weight   *float64 `json:"weight,omitempty"`
+	TaxClass TaxClass `json:"taxClass"`
+	Archived bool     `json:"archived,omitempty"`
+
+	ReviewCount        int         `json:"reviewCount"`
+	AverageRating      float64     `json:"averageRating"`
+	RatingDistribution map[int]int `json:"ratingDistribution"`
 }
 
 type Category struct {
@@ -34,27 +46,208 @@ type Category struct {
 }
 
 type Review struct {
-	ID          int     `json:"id"`
-	AccID       int     `json:"accID"`
-	ProdID      int     `json:"prodID"`
-	RatingGiven float64 `json:"ratingGiven"`
-	Text        string  `json:"text"`
+	ID           int       `json:"id"`
+	AccID        int       `json:"accID"`
+	ProdID       int       `json:"prodID"`
+	RatingGiven  float64   `json:"ratingGiven"`
+	Text         string    `json:"text"`
+	CreatedAt    time.Time `json:"createdAt"`
+	HelpfulCount int       `json:"helpfulCount"`
+	Verified     bool      `json:"verified"`
+
+	Status           ReviewStatus `json:"status"`
+	ModerationReason string       `json:"moderationReason,omitempty"`
+
+	UnhelpfulCount int          `json:"unhelpfulCount"`
+	MerchantReply  *ReviewReply `json:"merchantReply,omitempty"`
+
+	Images []*ReviewImage `json:"images"`
+}
+
+type ReviewImage struct {
+	ID           int          `json:"id"`
+	ReviewID     int          `json:"reviewID"`
+	Key          string       `json:"-"`
+	ThumbKey     string       `json:"-"`
+	URL          string       `json:"url"`
+	ThumbnailURL string       `json:"thumbnailURL"`
+	Status       ReviewStatus `json:"status"`
+}
+
+type ReviewReply struct {
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ReviewReport struct {
+	ID        int       `json:"id"`
+	ReviewID  int       `json:"reviewID"`
+	AccID     int       `json:"accID"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+	Resolved  bool      `json:"resolved"`
+}
+
+type ReviewStatus string
+
+const (
+	ReviewStatusPending  ReviewStatus = "pending"
+	ReviewStatusApproved ReviewStatus = "approved"
+	ReviewStatusRejected ReviewStatus = "rejected"
+	// ReviewStatusDuplicate archives older reviews of a product by the same
+	// account, written before every account could review a product only
+	// once. They are kept for moderators but never shown or counted.
+	ReviewStatusDuplicate ReviewStatus = "duplicate"
+)
+
+type ReviewQuery struct {
+	Page     int
+	PageSize int
+	Sort     string
+	Rating   int
+	Verified *bool
+}
+
+type ReviewPage struct {
+	Reviews  []*Review `json:"reviews"`
+	Total    int       `json:"total"`
+	Page     int       `json:"page"`
+	PageSize int       `json:"pageSize"`
 }
 
 type Cart struct {
 	CartID int `json:"cartID"`
 	UserID int `json:"userID"`
+	// Destination and ShippingMethod are the customer's shipping choice,
+	// empty until one is made.
+	Destination    Destination `json:"destination"`
+	ShippingMethod string      `json:"shippingMethod"`
+}
+
+// Destination is the part of an address shipping rates depend on.
+type Destination struct {
+	Country    string `json:"country"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postalCode"`
+}
+
+type ShippingQuote struct {
+	Method  string       `json:"method"`
+	Name    string       `json:"name"`
+	Zone    string       `json:"zone"`
+	Amount  money.Amount `json:"amount"`
+	MinDays int          `json:"minDays"`
+	MaxDays int          `json:"maxDays"`
+}
+
+type SelectShippingRequest struct {
+	Country    string `json:"country"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postalCode"`
+	Method     string `json:"method"`
+}
+
+// AbandonedCart is an account cart that has not been touched for a while.
+type AbandonedCart struct {
+	CartID    int       `json:"cartID"`
+	UserID    int       `json:"userID"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"firstName"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Items     int       `json:"items"`
+}
+
+type CartAbandonmentStats struct {
+	Idle            string       `json:"idle"`
+	Carts           int          `json:"carts"`
+	Abandoned       int          `json:"abandoned"`
+	AbandonmentRate float64      `json:"abandonmentRate"`
+	AbandonedValue  money.Amount `json:"abandonedValue"`
+	Reminded        int          `json:"reminded"`
+	Recovered       int          `json:"recovered"`
 }
 
 type ProductCart struct {
 	CartID   int      `json:"cartID"`
 	ProdID int `json:"prodID"`
 	Quantity int      `json:"quantity"`
+	// UnitPrice is the product's price when it was put in the cart.
+	UnitPrice *money.Amount `json:"unitPrice,omitempty"`
 }
 
 type ProductQuantity struct {
 	Product  *Product `json:"product"`
 	Quantity int      `json:"quantity"`
+	// AddedPrice is the price the customer saw when adding the product, nil
+	// if unknown.
+	AddedPrice *money.Amount `json:"-"`
+}
+
+type Wishlist struct {
+	ID         int        `json:"id"`
+	AccID      int        `json:"accID"`
+	Name       string     `json:"name"`
+	ShareToken string     `json:"shareToken,omitempty"`
+	Products   []*Product `json:"products"`
+}
+
+// CartLine is a priced cart item. LineTotal already has Discount taken off.
+type CartLine struct {
+	Product   *Product       `json:"product"`
+	Quantity  int            `json:"quantity"`
+	UnitPrice money.Amount   `json:"unitPrice"`
+	Discount  money.Amount   `json:"discount"`
+	LineTotal money.Amount   `json:"lineTotal"`
+	Tax       money.Amount   `json:"tax"`
+	Warnings  []*CartWarning `json:"warnings,omitempty"`
+}
+
+type CartWarningCode string
+
+const (
+	CartWarningPriceChanged      CartWarningCode = "price_changed"
+	CartWarningOutOfStock        CartWarningCode = "out_of_stock"
+	CartWarningInsufficientStock CartWarningCode = "insufficient_stock"
+	CartWarningArchived          CartWarningCode = "archived"
+)
+
+// CartWarning tells the customer that a cart line changed since it was added.
+type CartWarning struct {
+	Code     CartWarningCode `json:"code"`
+	Message  string          `json:"message"`
+	OldPrice *money.Amount   `json:"oldPrice,omitempty"`
+	NewPrice *money.Amount   `json:"newPrice,omitempty"`
+}
+
+type CartAdjustment struct {
+	Code        string       `json:"code,omitempty"`
+	Description string       `json:"description"`
+	Amount      money.Amount `json:"amount"`
+	PromotionID int          `json:"-"`
+}
+
+// CartSummary is a priced cart. If TaxIncluded is set the prices already
+// contain Tax and it is not added to Total.
+type CartSummary struct {
+	CartID         int               `json:"cartID"`
+	UserID         int               `json:"-"`
+	Destination    Destination       `json:"destination"`
+	ShippingMethod string            `json:"shippingMethod"`
+	Lines          []*CartLine       `json:"lines"`
+	Subtotal       money.Amount      `json:"subtotal"`
+	Discounts      []*CartAdjustment `json:"discounts"`
+	Discount       money.Amount      `json:"discount"`
+	Tax            money.Amount      `json:"tax"`
+	Taxes          []*TaxLine        `json:"taxes"`
+	TaxIncluded    bool              `json:"taxIncluded"`
+	Shipping       money.Amount      `json:"shipping"`
+	Total          money.Amount      `json:"total"`
+}
+
+type RelatedProduct struct {
+	Product             *Product `json:"product"`
+	Score               float64  `json:"score"`
+	TimesBoughtTogether int      `json:"timesBoughtTogether"`
 }
 
 func NewAccount(firstName, lastName, email, password string, userType UserType) (*Account, error) {
@@ -90,6 +283,14 @@ func NewProduct(name string, price float64, measurements, description, packaging
 	}
 }
 
+func NewWishlist(accID int, name string) *Wishlist {
+	return &Wishlist{
+		AccID:    accID,
+		Name:     name,
+		Products: []*Product{},
+	}
+}
+
 func NewCart(userID int) *Cart {
 	return &Cart{
 		UserID: userID,
@@ -105,24 +306,65 @@ type CreateAccountRequest struct {
 }
 
 type CreateProductRequest struct {
-	Name         string  `json:"name"`
-	Price        float64 `json:"price"`
-	Measurements string  `json:"measurements"`
-	Description  string  `json:"description"`
-	Packaging    string  `json:"packaging"`
+	Name         string   `json:"name"`
+	Price        float64  `json:"price"`
+	Measurements string   `json:"measurements"`
+	Description  string   `json:"description"`
+	Packaging    string   `json:"packaging"`
+	Stock        *int     `json:"stock"`
+	Weight       *float64 `json:"weight"`
+	TaxClass     TaxClass `json:"taxClass"`
 }
 
 type CreateReviewRequest struct {
-	AccID       int     `json:"accID"`
 	ProdID      int     `json:"prodID"`
 	RatingGiven float64 `json:"ratingGiven"`
 	Text        string  `json:"text"`
 }
 
+type ReviewVoteRequest struct {
+	Helpful bool `json:"helpful"`
+}
+
+type ReviewReplyRequest struct {
+	Text string `json:"text"`
+}
+
+type ReportReviewRequest struct {
+	Reason string `json:"reason"`
+}
+
+type RejectReviewRequest struct {
+	Reason string `json:"reason"`
+}
+
+type UpdateReviewRequest struct {
+	RatingGiven float64 `json:"ratingGiven"`
+	Text        string  `json:"text"`
+}
+
 type CreateCartRequest struct {
 	UserID int `json:"userID"`
 }
 
+type CreateWishlistRequest struct {
+	Name string `json:"name"`
+}
+
+type WishlistItemRequest struct {
+	ProdID   int  `json:"prodID"`
+	FromCart bool `json:"fromCart"`
+}
+
+type MoveToCartRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+type CartItemRequest struct {
+	ProdID   int `json:"prodID"`
+	Quantity int `json:"quantity"`
+}
+
 type CreateCategoryRequest struct {
 	Name string `json:"name"`
 }
@@ -0,0 +1,94 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventProductCreated     EventType = "product.created"
+	EventProductUpdated     EventType = "product.updated"
+	EventAccountCreated     EventType = "account.created"
+	EventOrderCreated       EventType = "order.created"
+	EventOrderStatusChanged EventType = "order.status_changed"
+	// EventAll subscribes a webhook to every event.
+	EventAll EventType = "*"
+)
+
+var EventTypes = []EventType{
+	EventProductCreated,
+	EventProductUpdated,
+	EventAccountCreated,
+	EventOrderCreated,
+	EventOrderStatusChanged,
+}
+
+// Event is a change other systems may want to react to. It is written to
+// the outbox in the same transaction as the change itself.
+type Event struct {
+	ID        int             `json:"id"`
+	Type      EventType       `json:"type"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// OrderStatusChange is the data of an order.status_changed event.
+type OrderStatusChange struct {
+	OrderID int         `json:"orderID"`
+	AccID   int         `json:"accID"`
+	From    OrderStatus `json:"from"`
+	To      OrderStatus `json:"to"`
+	Note    string      `json:"note,omitempty"`
+}
+
+// WebhookSubscription asks for the given events to be posted to URL, signed
+// with Secret.
+type WebhookSubscription struct {
+	ID        int         `json:"id"`
+	URL       string      `json:"url"`
+	Events    []EventType `json:"events"`
+	Secret    string      `json:"secret,omitempty"`
+	Active    bool        `json:"active"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+type WebhookSubscriptionRequest struct {
+	URL    string      `json:"url"`
+	Events []EventType `json:"events"`
+	Secret string      `json:"secret"`
+	Active *bool       `json:"active"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event sent to one subscription, including its
+// retries. Replaying a delivery creates a new one.
+type WebhookDelivery struct {
+	ID             int            `json:"id"`
+	SubscriptionID int            `json:"subscriptionID"`
+	Event          *Event         `json:"event"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  *time.Time     `json:"nextAttemptAt,omitempty"`
+	LastStatusCode int            `json:"lastStatusCode,omitempty"`
+	LastError      string         `json:"lastError,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	DeliveredAt    *time.Time     `json:"deliveredAt,omitempty"`
+}
+
+// DeliveryAttempt is the outcome of posting a delivery once. A failed
+// attempt is retried after RetryIn, or never if it is zero.
+type DeliveryAttempt struct {
+	StatusCode int
+	Error      string
+	Succeeded  bool
+	RetryIn    time.Duration
+}
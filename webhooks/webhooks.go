@@ -0,0 +1,157 @@
+package webhooks
+
+import (
+	"3legant/types"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	DefaultMaxAttempts = 8
+	DefaultBaseDelay   = 30 * time.Second
+	maxDelay           = 6 * time.Hour
+	batchSize          = 100
+)
+
+type Store interface {
+	DispatchEvents(int) (int, error)
+	ClaimDueDeliveries(int, time.Duration) ([]*types.WebhookDelivery, error)
+	RecordDeliveryAttempt(int, *types.DeliveryAttempt) error
+	GetWebhookSubscription(int) (*types.WebhookSubscription, error)
+}
+
+// Dispatcher moves events from the outbox to the subscribed webhooks. A
+// failed delivery is retried with exponential backoff, BaseDelay after the
+// first attempt and twice as long after every further one, until
+// MaxAttempts attempts failed.
+type Dispatcher struct {
+	Store       Store
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		Store:       store,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: DefaultMaxAttempts,
+		BaseDelay:   DefaultBaseDelay,
+	}
+}
+
+// Run fans out new events and sends the deliveries that are due.
+func (d *Dispatcher) Run() error {
+	for {
+		n, err := d.Store.DispatchEvents(batchSize)
+		if err != nil {
+			return err
+		}
+		if n < batchSize {
+			break
+		}
+	}
+
+	// lease the deliveries for longer than sending them can take
+	lease := time.Duration(batchSize+1) * d.Client.Timeout
+	deliveries, err := d.Store.ClaimDueDeliveries(batchSize, lease)
+	if err != nil {
+		return err
+	}
+	subs := map[int]*types.WebhookSubscription{}
+	failed := 0
+	for _, delivery := range deliveries {
+		sub, ok := subs[delivery.SubscriptionID]
+		if !ok {
+			if sub, err = d.Store.GetWebhookSubscription(delivery.SubscriptionID); err != nil {
+				return err
+			}
+			subs[sub.ID] = sub
+		}
+		attempt := d.send(sub, delivery)
+		if !attempt.Succeeded {
+			failed++
+		}
+		if err := d.Store.RecordDeliveryAttempt(delivery.ID, attempt); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d webhook deliveries failed", failed, len(deliveries))
+	}
+	return nil
+}
+
+// send posts the delivery's event to the subscription once. Any 2xx answer
+// counts as delivered.
+func (d *Dispatcher) send(sub *types.WebhookSubscription, delivery *types.WebhookDelivery) *types.DeliveryAttempt {
+	attempt := &types.DeliveryAttempt{}
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(delivery.Event.Type))
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		attempt.StatusCode = resp.StatusCode
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			attempt.Succeeded = true
+			return attempt
+		}
+		err = fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	attempt.Error = err.Error()
+	if delivery.Attempts+1 < d.MaxAttempts {
+		attempt.RetryIn = d.backoff(delivery.Attempts + 1)
+	}
+	return attempt
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(failed int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < failed && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp and body,
+// joined by a dot. Receivers recompute it with their secret and should
+// reject deliveries with an old timestamp to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,88 @@
+package webhooks
+
+import (
+	"3legant/types"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// printf '1700000000.{"id":1}' | openssl dgst -sha256 -hmac s3cret
+	want := "ee0658aa4e37018df69c24227df01e0f680eb3b87c7f1f9bd936e283cfe01d9b"
+	if got := Sign("s3cret", "1700000000", []byte(`{"id":1}`)); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("other", "1700000000", []byte(`{"id":1}`)) == want {
+		t.Error("signature does not depend on the secret")
+	}
+	if Sign("s3cret", "1700000001", []byte(`{"id":1}`)) == want {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseDelay: 30 * time.Second}
+	tests := []struct {
+		failed int
+		want   time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{8, 64 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, maxDelay},
+		{50, maxDelay},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.failed); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failed, got, tt.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	status := http.StatusOK
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(nil)
+	sub := &types.WebhookSubscription{ID: 1, URL: srv.URL, Secret: "s3cret"}
+	delivery := &types.WebhookDelivery{ID: 7, Event: &types.Event{
+		ID:   3,
+		Type: types.EventProductCreated,
+		Data: json.RawMessage(`{"id":5}`),
+	}}
+
+	attempt := d.send(sub, delivery)
+	if !attempt.Succeeded || attempt.StatusCode != http.StatusOK {
+		t.Fatalf("attempt = %+v, want success", attempt)
+	}
+	if sig := Sign("s3cret", got.Header.Get(TimestampHeader), body); got.Header.Get(SignatureHeader) != sig {
+		t.Errorf("signature %s does not match the body", got.Header.Get(SignatureHeader))
+	}
+	if got.Header.Get(EventHeader) != string(types.EventProductCreated) || got.Header.Get(DeliveryHeader) != "7" {
+		t.Errorf("headers = %v", got.Header)
+	}
+
+	status = http.StatusInternalServerError
+	attempt = d.send(sub, delivery)
+	if attempt.Succeeded || attempt.StatusCode != status || attempt.RetryIn != d.BaseDelay {
+		t.Errorf("attempt = %+v, want a failure retried after %v", attempt, d.BaseDelay)
+	}
+
+	delivery.Attempts = d.MaxAttempts - 1
+	if attempt = d.send(sub, delivery); attempt.RetryIn != 0 {
+		t.Errorf("last attempt RetryIn = %v, want no retry", attempt.RetryIn)
+	}
+}